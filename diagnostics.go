@@ -0,0 +1,96 @@
+package goini
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseError describes a line that could not be understood. Load returns it
+// with the Strict option, otherwise it is kept in Warnings.
+type ParseError struct {
+	File    string
+	Line    int // 1-based line number
+	Column  int // 1-based byte offset in the line
+	Snippet string
+	Reason  string
+}
+
+func (e *ParseError) Error() string {
+	pos := fmt.Sprintf("%d:%d", e.Line, e.Column)
+	if e.File != "" {
+		pos = e.File + ":" + pos
+	}
+	return fmt.Sprintf("goini: %s: %s: %q", pos, e.Reason, e.Snippet)
+}
+
+// Warnings returns the lines that were ignored while loading the file.
+func (t *TINIFile) Warnings() []*ParseError {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return append([]*ParseError(nil), t.warnings...)
+}
+
+// lineProblem returns the reason and the position of what is wrong with a
+// processed line, or an empty reason.
+func (t *TINIFile) lineProblem(r _TLine) (string, int) {
+	line := r.Line
+	if i := strings.IndexByte(line, '\n'); i >= 0 && r.Mode != KEY {
+		line = line[:i]
+	}
+	begin := len(line) - len(strings.TrimLeft(line, " \t"))
+	rest := strings.TrimRight(line[begin:], " \t")
+
+	switch r.Mode {
+	case IGNORED:
+		if len(rest) == 0 || t.commentAt(rest) {
+			return "", 0
+		}
+		if rest[0] == _Section[0] {
+			return "unclosed section header", begin
+		}
+		return "missing delimiter", begin
+	case SECTION:
+		if len(r.Section) == 0 {
+			return "empty section name", begin
+		}
+		end := strings.IndexByte(line, _Section[1]) + 1
+		after := strings.TrimLeft(line[end:], " \t")
+		if len(after) > 0 && (t.options.NoInlineComments || !t.commentAt(after)) {
+			return "unexpected text after section header", len(line) - len(after)
+		}
+	case KEY:
+		if len(r.Key) == 0 {
+			return "empty key", begin
+		}
+		if len(r.Value) > 0 && isQuote(r.Value[0]) &&
+			t.closingQuote(r.Line, r.ValueBegin) < 0 {
+			return "unclosed quote", r.ValueBegin
+		}
+	}
+	return "", 0
+}
+
+// duplicateProblem returns the reason and the position of a repeated section
+// or key not allowed by the DuplicatePolicy, or an empty reason.
+func (t *TINIFile) duplicateProblem(r _TLine, seen map[string]bool) (string, int) {
+	policy := t.options.DuplicatePolicy
+	if policy != DuplicateError && policy != DuplicateMergeSections {
+		return "", 0
+	}
+	begin := len(r.Line) - len(strings.TrimLeft(r.Line, " \t"))
+	switch r.Mode {
+	case SECTION:
+		name := "[" + t.sectionKey(r.Section)
+		if seen[name] && policy == DuplicateError {
+			return "duplicate section", begin
+		}
+		seen[name] = true
+	case KEY:
+		name := t.sectionKey(r.Section) + "]" + t.sectionKey(r.Key)
+		if seen[name] {
+			return "duplicate key", begin
+		}
+		seen[name] = true
+	}
+	return "", 0
+}
@@ -0,0 +1,93 @@
+package goini
+
+import (
+	"os"
+	"strings"
+)
+
+// TEnvOverride is an environment variable that replaces a key of the file.
+type TEnvOverride struct {
+	Name      string // of the environment variable
+	Section   string
+	Key       string
+	Value     string // from the environment
+	FileValue string // replaced by Value
+}
+
+// envName returns the environment variable for the key, the prefix, the
+// section and the key joined with the separator and upper-cased, other
+// characters than letters, digits and _ in the section and the key are
+// replaced by _.
+func (t *TINIFile) envName(section string, key string) string {
+	separator := t.options.EnvSeparator
+	if separator == "" {
+		separator = "_"
+	}
+	parts := []string{strings.ToUpper(t.options.EnvPrefix)}
+	if section != "" {
+		parts = append(parts, envPart(section))
+	}
+	parts = append(parts, envPart(key))
+	return strings.Join(parts, separator)
+}
+
+// envPart upper-cases the section or the key of an environment variable.
+func envPart(s string) string {
+	name := []byte(strings.ToUpper(s))
+	for i, c := range name {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			name[i] = '_'
+		}
+	}
+	return string(name)
+}
+
+// lookupEnv returns the value of the environment variable of the key when
+// EnvPrefix is set.
+func (t *TINIFile) lookupEnv(section string, key string) (TValue, bool) {
+	if t.options.EnvPrefix == "" {
+		return TValue{}, false
+	}
+	value, ok := os.LookupEnv(t.envName(section, key))
+	if !ok {
+		return TValue{}, false
+	}
+	return TValue{
+		Value:     []byte(value),
+		section:   section,
+		key:       key,
+		separator: t.arraySeparator(),
+	}, true
+}
+
+// EnvOverrides returns the keys of the file replaced by an environment
+// variable in file order. A variable for a key that is not in the file is
+// used by Get but not listed, its section and key can not be told apart from
+// its name.
+func (t *TINIFile) EnvOverrides() []TEnvOverride {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	overrides := []TEnvOverride{}
+	if t.options.EnvPrefix == "" {
+		return overrides
+	}
+	for _, section := range t.sectionNames() {
+		for _, key := range t.keys(section) {
+			name := t.envName(section, key)
+			if value, ok := os.LookupEnv(name); ok {
+				fileValue := ""
+				if i := t.findKey(section, key); i >= 0 {
+					fileValue = t.valueToRead(t.lines[i].Value)
+				}
+				overrides = append(overrides, TEnvOverride{
+					Name:      name,
+					Section:   section,
+					Key:       key,
+					Value:     value,
+					FileValue: fileValue,
+				})
+			}
+		}
+	}
+	return overrides
+}
@@ -0,0 +1,80 @@
+package goini
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Quoted values support the escape sequences \n \t \r \\ \" \' and \uXXXX,
+// unknown sequences are kept as they are.
+
+func quoteValue(v string) string {
+	var b strings.Builder
+	b.WriteByte(_FlagQuoting)
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; c {
+		case '\\':
+			b.WriteString(`\\`)
+		case '"':
+			b.WriteString(`\"`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if c < 32 || c == 127 {
+				fmt.Fprintf(&b, `\u%04x`, c)
+			} else {
+				b.WriteByte(c) // bytes that are not UTF-8 are kept as they are
+			}
+		}
+	}
+	b.WriteByte(_FlagQuoting)
+	return b.String()
+}
+
+// unquoteValue removes the quotes around v, raw keeps the escape sequences.
+func unquoteValue(v string, raw bool) string {
+	if len(v) < 2 || (v[0] != '"' && v[0] != '\'') || v[len(v)-1] != v[0] {
+		return v
+	}
+	v = v[1 : len(v)-1]
+	if raw || !strings.Contains(v, `\`) {
+		return v
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] != '\\' || i+1 == len(v) {
+			b.WriteByte(v[i])
+			continue
+		}
+		switch v[i+1] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case '\\', '"', '\'':
+			b.WriteByte(v[i+1])
+		case 'u':
+			if i+6 <= len(v) {
+				if r, err := strconv.ParseUint(v[i+2:i+6], 16, 32); err == nil && utf8.ValidRune(rune(r)) {
+					b.WriteRune(rune(r))
+					i += 5
+					continue
+				}
+			}
+			b.WriteString(v[i : i+2])
+		default:
+			b.WriteString(v[i : i+2])
+		}
+		i++
+	}
+	return b.String()
+}
@@ -0,0 +1,14 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !illumos && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!illumos,!linux,!netbsd,!openbsd,!solaris
+
+package goini
+
+import "os"
+
+func chown(f *os.File, info os.FileInfo) error {
+	return nil
+}
+
+func syncDir(dir string) error {
+	return nil
+}
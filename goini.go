@@ -1,12 +1,16 @@
 package goini
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -23,6 +27,12 @@ const (
 	IsWindows = runtime.GOOS == "windows"
 )
 
+const (
+	LineEndingLF   = "\n"
+	LineEndingCRLF = "\r\n"
+	LineEndingCR   = "\r"
+)
+
 type _EType int8
 
 const (
@@ -32,28 +42,36 @@ const (
 )
 
 type _TLine struct {
-	Mode    _EType
-	Section string
-	Key     string
-	Value   string
-	Line    string
+	Mode       _EType
+	Section    string
+	Key        string
+	Value      string
+	Line       string
+	ValueBegin int // position of Value in Line
+	ValueEnd   int
+	Number     int // line number in the file when it was loaded, 0 for new lines
 }
 
 type _TSection struct {
 	Section string
+	Name    string // Section as written in the file
 	Begin   int
 	End     int
 }
 
-var _Section []byte = []byte{91, 93}                  // [ ]
-var _ArraySeparator []byte = []byte{44}               // 44 is the ascii code for comma
-var _FlagComments []byte = []byte{35, 39, 47, 59, 96} // 47 double
-var _IgnoredSpaces []byte = []byte{9, 10, 32}         // Bool returns the value as a boolean.
-var _KeyValueDiff byte = byte(61)                     // 61 is the ascii code for =
-var _FlagQuoting byte = byte(34)                      // 34 is the ascii code for "
+var _Section []byte = []byte{91, 93}          // [ ]
+var _ArraySeparator []byte = []byte{44}       // 44 is the ascii code for comma
+var _IgnoredSpaces []byte = []byte{9, 10, 32} // Bool returns the value as a boolean.
+var _KeyValueDiff byte = byte(61)             // 61 is the ascii code for =
+var _FlagQuoting byte = byte(34)              // 34 is the ascii code for "
+var _Quotes []byte = []byte{34, 39}           // " '
 
 type TValue struct {
-	Value []byte
+	Value     []byte
+	section   string
+	key       string
+	separator string   // array separator of the file
+	array     []string // items joined with the separator of the file on Set
 }
 
 type TINIFile struct {
@@ -61,26 +79,81 @@ type TINIFile struct {
 	sections   []_TSection
 	Filename   string
 	TotalLines int
+	LineEnding string // line ending detected on Load
+	noFinalEOL bool
 	options    *TOptions
+	warnings   []*ParseError // lines ignored on Load
+
+	sectionIndex map[string]int              // normalized section, position in sections
+	keyIndex     map[string]map[string][]int // normalized section and key, lines of the key
+
+	mu     sync.RWMutex // the exported methods can be used from many goroutines
+	saveMu sync.Mutex   // one Save at a time
+	shared bool         // lines shared with a clone, copied on the next change
 }
 
+var _CommentPrefixes []string = []string{"#", "'", "//", ";", "`"}
+var _Delimiters []string = []string{string(_KeyValueDiff)}
+
+type TMultiLine int8
+
+const (
+	MultiLineNone      TMultiLine = iota
+	MultiLineBackslash            // value \ at the end of the line continues on the next one
+	MultiLineIndented             // lines indented more than the key continue the value
+)
+
+type TDuplicatePolicy int8
+
+const (
+	DuplicateFirst         TDuplicatePolicy = iota // repeated sections are merged, Get returns the first key
+	DuplicateLast                                  // repeated sections are merged, Get returns the last key
+	DuplicateList                                  // repeated sections are merged, Get returns every value as a list, Set writes a key for each item, DeleteKey removes them all
+	DuplicateMergeSections                         // like DuplicateFirst, but Load fails on a key repeated in the merged section
+	DuplicateError                                 // Load fails on a repeated section or key
+)
+
 type TOptions struct {
 	Debug                  bool
 	CaseSensitive          bool
 	DontPreserveEmptyLines bool
 	ForceSaveWithoutQuotes bool
+	AtomicSave             bool     // Save writes a temp file and renames it over the original
+	Backups                int      // number of .bak copies kept by Save
+	LineEnding             string   // overrides the line ending used by Save
+	Delimiters             []string // between key and value, " " is any whitespace, default "="
+	CommentPrefixes        []string // default # ' // ; `
+	NoInlineComments       bool     // comments only at the beginning of the line
+	ArraySeparator         string   // default ,
+	MultiLine              TMultiLine
+	RawValues              bool // quoted values are read and written without escape sequences, a value with both quotes is escaped
+	Strict                 bool // Load fails with a *ParseError instead of ignoring a line
+	DuplicatePolicy        TDuplicatePolicy
+	EnvPrefix              string // Get reads PREFIX_SECTION_KEY from the environment before the file
+	EnvSeparator           string // between the prefix, the section and the key, default _
 }
 
-var timeMark time.Time
-
 func (t *TINIFile) Options(o *TOptions) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.own()
 	(*t).options = o
+	if o != nil {
+		// CaseSensitive changes the names of the sections and the keys in the index
+		for i := range t.sections {
+			t.sections[i].Section = t.sectionKey(t.sections[i].Name)
+		}
+		t.indexSections()
+		t.indexKeys()
+	}
 }
 
 func New(o *TOptions) *TINIFile {
 	t := TINIFile{}
 	t.lines = []_TLine{}
-	t.sections = []_TSection{}
+	t.sections = []_TSection{{Section: "", Name: "", Begin: 0, End: 0}} // global section, keys before the first section
+	t.sectionIndex = map[string]int{"": 0}
+	t.keyIndex = map[string]map[string][]int{}
 	t.Filename = ""
 	t.TotalLines = 0
 	t.options = o
@@ -102,20 +175,48 @@ func ReadFile(Path string, EmptyLines bool) ([]string, error) {
 	}
 	defer f.Close()
 
+	lines, _, _, err := readLines(f, EmptyLines)
+	return lines, err
+}
+
+// readLines splits r on LF, CRLF and CR, returning the first line ending found
+// and if the data ends with a line ending.
+func readLines(r io.Reader, EmptyLines bool) ([]string, string, bool, error) {
 	var (
-		buf   []byte = make([]byte, 32*1024)
-		lines []string
-		line  []byte = []byte{}
+		buf        []byte = make([]byte, 32*1024)
+		lines      []string
+		line       []byte = []byte{}
+		lineEnding string
+		pendingCR  bool
+		detectCRLF bool
+		finalEOL   bool
 	)
 	for {
-		n, err := f.Read(buf)
+		n, err := r.Read(buf)
 		if n > 0 {
 			for i := 0; i < n; i++ {
-				if buf[i] == 13 {
-					// ignore \r
+				if buf[i] == 10 && pendingCR {
+					// \r\n, the line was already added on \r
+					pendingCR = false
+					if detectCRLF {
+						lineEnding = LineEndingCRLF
+					}
+					detectCRLF = false
 					continue
-				} else if buf[i] == 10 {
-					if len(line) > 0 || (EmptyLines && buf[i] == 10) {
+				}
+				pendingCR = false
+				detectCRLF = false
+				finalEOL = buf[i] == 10 || buf[i] == 13
+				if finalEOL {
+					if lineEnding == "" {
+						lineEnding = LineEndingLF
+						if buf[i] == 13 {
+							lineEnding = LineEndingCR
+							detectCRLF = true
+						}
+					}
+					pendingCR = buf[i] == 13
+					if len(line) > 0 || EmptyLines {
 						lines = append(lines, string(line))
 						line = []byte{}
 					}
@@ -128,20 +229,53 @@ func ReadFile(Path string, EmptyLines bool) ([]string, error) {
 			break
 		}
 		if err != nil {
-			return nil, fmt.Errorf("read %d bytes: %v", n, err)
+			return nil, "", false, fmt.Errorf("read %d bytes: %v", n, err)
 		}
 	}
-	if len(line) > 0 || EmptyLines {
+	if len(line) > 0 || (EmptyLines && !finalEOL) {
 		lines = append(lines, string(line))
 	}
 
-	return lines, nil
+	return lines, lineEnding, finalEOL, nil
 }
 
 func Load(Path string, o *TOptions) (*TINIFile, error) {
+	f, err := os.Open(Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return load(f, Path, o)
+}
+
+// LoadReader parses an INI file from r.
+func LoadReader(r io.Reader, o *TOptions) (*TINIFile, error) {
+	return load(r, "", o)
+}
+
+// LoadBytes parses an INI file from b.
+func LoadBytes(b []byte, o *TOptions) (*TINIFile, error) {
+	return load(bytes.NewReader(b), "", o)
+}
+
+// LoadFS parses the INI file called name from fsys, like an embed.FS.
+func LoadFS(fsys fs.FS, name string, o *TOptions) (*TINIFile, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return load(f, name, o)
+}
+
+func load(r io.Reader, Path string, o *TOptions) (*TINIFile, error) {
 	t := TINIFile{}
 	t.lines = []_TLine{}
-	t.sections = []_TSection{}
+	t.sections = []_TSection{{Section: "", Name: "", Begin: 0, End: 0}} // global section, keys before the first section
+	t.sectionIndex = map[string]int{"": 0}
+	t.keyIndex = map[string]map[string][]int{}
 	t.Filename = Path
 	t.TotalLines = 0
 	t.options = o
@@ -153,10 +287,18 @@ func Load(Path string, o *TOptions) (*TINIFile, error) {
 			DontPreserveEmptyLines: false,
 		}
 	}
-	if t.options.Debug {
-		timeMark = time.Now()
-	}
-	if lines, err := ReadFile(Path, !t.options.DontPreserveEmptyLines); err == nil {
+	start := time.Now()
+	if all, lineEnding, finalEOL, err := readLines(r, true); err == nil {
+		t.LineEnding = lineEnding
+		t.noFinalEOL = !finalEOL && len(all) > 0
+		lines := make([]string, 0, len(all))
+		numbers := make([]int, 0, len(all)) // line number in the file of each line
+		for i := range all {
+			if len(all[i]) > 0 || !t.options.DontPreserveEmptyLines {
+				lines = append(lines, all[i])
+				numbers = append(numbers, i+1)
+			}
+		}
 		lineNumber := 0
 		if t.options.Debug {
 			fmt.Println("Total lines: ", len(lines))
@@ -165,407 +307,1270 @@ func Load(Path string, o *TOptions) (*TINIFile, error) {
 			}
 		}
 
-		for i := range lines {
-			l := strings.TrimSpace(lines[i])
-			if lineNumber == 0 {
-				t.lines = append(t.lines, t.processLine(l, _TLine{}))
-			} else {
-				t.lines = append(t.lines, t.processLine(l, t.lines[lineNumber-1]))
+		seen := map[string]bool{} // sections and keys found, for DuplicatePolicy
+		for i := 0; i < len(lines); i++ {
+			prevLine := _TLine{}
+			if lineNumber > 0 {
+				prevLine = t.lines[lineNumber-1]
+			}
+			l := lines[i]
+			r := t.processLine(l, prevLine)
+			if r.Mode == KEY {
+				// multi-line values are processed as a single line
+				for next := t.continuation(l, r, lines[i+1:]); next > 0; next = t.continuation(l, r, lines[i+1:]) {
+					l += "\n" + strings.Join(lines[i+1:i+1+next], "\n")
+					i += next
+					r = t.processLine(l, prevLine)
+				}
+			}
+			r.Number = numbers[i-strings.Count(l, "\n")]
+			reason, column := t.lineProblem(r)
+			fatal := t.options.Strict
+			if reason == "" {
+				reason, column = t.duplicateProblem(r, seen)
+				fatal = true
+			}
+			if reason != "" {
+				snippet := l
+				if n := strings.IndexByte(snippet, '\n'); n >= 0 {
+					snippet = snippet[:n]
+				}
+				perr := &ParseError{File: Path, Line: r.Number, Column: column + 1, Snippet: snippet, Reason: reason}
+				if fatal {
+					return nil, perr
+				}
+				t.warnings = append(t.warnings, perr)
 			}
+			t.lines = append(t.lines, r)
+			t.indexLine(len(t.lines) - 1)
 			lineNumber++
 		}
 	} else {
 		return nil, err
 	}
 	if t.options.Debug {
-		fmt.Println("File loaded on ", time.Since(timeMark))
+		fmt.Println("File loaded on ", time.Since(start))
 	}
 	return &t, nil
 }
 
 func (t *TINIFile) Save(Path string) error {
-	if f, err := os.Create(Path); err != nil {
+	t.saveMu.Lock()
+	defer t.saveMu.Unlock()
+	t.mu.RLock()
+	o := t.options
+	t.mu.RUnlock()
+
+	if o.Backups > 0 {
+		if err := rotateBackups(Path, o.Backups); err != nil {
+			return err
+		}
+	}
+	if o.AtomicSave {
+		return t.saveAtomic(Path)
+	}
+
+	f, err := os.Create(Path)
+	if err != nil {
 		return err
-	} else {
-		defer f.Close()
+	}
+
+	w := bufio.NewWriter(f)
+	if _, err := t.WriteTo(w); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// SaveAtomic saves the file like Save with AtomicSave enabled.
+func (t *TINIFile) SaveAtomic(Path string) error {
+	t.saveMu.Lock()
+	defer t.saveMu.Unlock()
+	t.mu.RLock()
+	o := t.options
+	t.mu.RUnlock()
 
-		lineBreak := "\r"
-		if IsWindows {
-			lineBreak = "\r\n"
+	if o.Backups > 0 {
+		if err := rotateBackups(Path, o.Backups); err != nil {
+			return err
 		}
-		for i := range t.lines {
-			if _, err := f.Write([]byte(t.lines[i].Line + lineBreak)); err != nil {
-				panic(err)
-			}
+	}
+	return t.saveAtomic(Path)
+}
+
+func (t *TINIFile) saveAtomic(Path string) error {
+	return writeAtomic(Path, func(w io.Writer) error {
+		_, err := t.WriteTo(w)
+		return err
+	})
+}
+
+// WriteTo writes the INI file contents to w, it implements io.WriterTo.
+func (t *TINIFile) WriteTo(w io.Writer) (int64, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	lineBreak := t.lineEnding()
+
+	var total int64
+	for i := range t.lines {
+		line := t.lines[i].Line
+		if lineBreak != "\n" && strings.Contains(line, "\n") {
+			line = strings.Replace(line, "\n", lineBreak, -1) // multi-line values
+		}
+		if i < len(t.lines)-1 || !t.noFinalEOL {
+			line += lineBreak
+		}
+		n, err := io.WriteString(w, line)
+		total += int64(n)
+		if err != nil {
+			return total, err
 		}
 	}
-	return nil
+	return total, nil
+}
+
+// lineEnding returns the line ending used to save, the one in the options,
+// the one detected on Load or the system one.
+func (t *TINIFile) lineEnding() string {
+	if t.options.LineEnding != "" {
+		return t.options.LineEnding
+	}
+	if t.LineEnding != "" {
+		return t.LineEnding
+	}
+	if IsWindows {
+		return LineEndingCRLF
+	}
+	return LineEndingLF
+}
+
+// Bytes returns the INI file contents as they would be saved.
+func (t *TINIFile) Bytes() []byte {
+	var buf bytes.Buffer
+	t.WriteTo(&buf)
+	return buf.Bytes()
+}
+
+// String returns the INI file contents as they would be saved.
+func (t *TINIFile) String() string {
+	return string(t.Bytes())
 }
 
 // Logic
 
+func (t *TINIFile) delimiters() []string {
+	if len(t.options.Delimiters) > 0 {
+		return t.options.Delimiters
+	}
+	return _Delimiters
+}
+
+func (t *TINIFile) commentPrefixes() []string {
+	if len(t.options.CommentPrefixes) > 0 {
+		return t.options.CommentPrefixes
+	}
+	return _CommentPrefixes
+}
+
+func (t *TINIFile) arraySeparator() string {
+	if len(t.options.ArraySeparator) > 0 {
+		return t.options.ArraySeparator
+	}
+	return string(_ArraySeparator)
+}
+
+// commentAt returns if a comment starts at the beginning of s.
+func (t *TINIFile) commentAt(s string) bool {
+	for _, prefix := range t.commentPrefixes() {
+		if len(prefix) > 0 && strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// delimiterAt returns the length of the delimiter at the beginning of s or 0,
+// a whitespace delimiter takes the spaces and one other delimiter after it.
+func (t *TINIFile) delimiterAt(s string) int {
+	whitespace := false
+	for _, delimiter := range t.delimiters() {
+		if delimiter == " " || delimiter == "\t" {
+			whitespace = true
+		} else if len(delimiter) > 0 && strings.HasPrefix(s, delimiter) {
+			return len(delimiter)
+		}
+	}
+	if !whitespace || len(s) == 0 || !isIgnoredSpace(s[0]) {
+		return 0
+	}
+
+	n := 0
+	for n < len(s) && isIgnoredSpace(s[n]) {
+		n++
+	}
+	for _, delimiter := range t.delimiters() {
+		if delimiter != " " && delimiter != "\t" && len(delimiter) > 0 && strings.HasPrefix(s[n:], delimiter) {
+			n += len(delimiter)
+			for n < len(s) && isIgnoredSpace(s[n]) {
+				n++
+			}
+			break
+		}
+	}
+	return n
+}
+
+// continuation returns how many of the next lines continue the value of the
+// key line r.
+func (t *TINIFile) continuation(line string, r _TLine, next []string) int {
+	if len(next) == 0 {
+		return 0
+	}
+	switch t.options.MultiLine {
+	case MultiLineBackslash:
+		if strings.HasSuffix(r.Value, "\\") && len(strings.TrimRight(line, " \t")) == r.ValueEnd {
+			return 1
+		}
+	case MultiLineIndented:
+		keyLine := line
+		if i := strings.IndexByte(line, '\n'); i >= 0 {
+			keyLine = line[:i]
+		}
+		indent := len(keyLine) - len(strings.TrimLeft(keyLine, " \t"))
+		// empty lines are part of the value if an indented line follows them
+		for i := range next {
+			trimmed := strings.TrimLeft(next[i], " \t")
+			if len(trimmed) > 0 {
+				if len(next[i])-len(trimmed) > indent {
+					return i + 1
+				}
+				return 0
+			}
+		}
+	}
+	return 0
+}
+
+// valueToRead joins the lines of a multi-line value and removes the quotes
+// and escape sequences.
+func (t *TINIFile) valueToRead(value string) string {
+	if t.options.MultiLine == MultiLineNone || !strings.Contains(value, "\n") {
+		return unquoteValue(value, t.options.RawValues)
+	}
+	lines := strings.Split(value, "\n")
+	for i := range lines {
+		if i < len(lines)-1 && t.options.MultiLine == MultiLineBackslash {
+			lines[i] = strings.TrimRight(strings.TrimSuffix(lines[i], "\\"), " \t")
+		}
+		if i > 0 {
+			lines[i] = strings.TrimLeft(lines[i], " \t")
+		}
+	}
+	return unquoteValue(strings.Join(lines, "\n"), t.options.RawValues)
+}
+
+// valueToSave is ValueToSave with the options of the file, it quotes and
+// escapes the value when it is needed to read it back. indent is the leading
+// whitespace of the key line, indented continuation lines go one level deeper.
+func (t *TINIFile) valueToSave(value TValue, indent string) string {
+	v := string(value.Value)
+	if value.array != nil {
+		v = strings.Join(value.array, t.arraySeparator())
+	}
+
+	if t.options.ForceSaveWithoutQuotes || t.options.RawValues {
+		quote := t.needsQuotes(strings.Replace(v, "\n", " ", -1)) || t.linesNeedQuotes(v)
+		v = t.multiLineToSave(v, indent)
+		if t.options.ForceSaveWithoutQuotes || !quote {
+			return v
+		}
+		// a raw value can not escape the quote around it, the other one is used
+		if !strings.ContainsRune(v, rune(_FlagQuoting)) {
+			return string(_FlagQuoting) + v + string(_FlagQuoting)
+		}
+		if !strings.ContainsRune(v, '\'') {
+			return "'" + v + "'"
+		}
+		return quoteValue(v) // with both quotes only the escaped value is kept
+	}
+
+	if t.needsQuotes(strings.Replace(v, "\n", " ", -1)) || t.linesNeedQuotes(v) ||
+		(t.options.MultiLine == MultiLineNone && strings.Contains(v, "\n")) {
+		return quoteValue(v)
+	}
+	return t.multiLineToSave(v, indent)
+}
+
+// needsQuotes returns if the value would not be read back without quotes.
+func (t *TINIFile) needsQuotes(v string) bool {
+	if len(v) == 0 {
+		return false
+	}
+	if !t.options.NoInlineComments {
+		for _, prefix := range t.commentPrefixes() {
+			if len(prefix) > 0 && strings.Contains(v, prefix) {
+				return true
+			}
+		}
+	}
+	if isQuote(v[0]) || isIgnoredSpace(v[0]) || isIgnoredSpace(v[len(v)-1]) {
+		return true
+	}
+	for i := range v {
+		if v[i] < 32 || v[i] == 127 {
+			return true
+		}
+	}
+	return false
+}
+
+// linesNeedQuotes returns if a line of the value would not be read back
+// without quotes, with MultiLineBackslash a \ at its end continues it and the
+// spaces around the lines of a multi-line value are trimmed.
+func (t *TINIFile) linesNeedQuotes(v string) bool {
+	if t.options.MultiLine == MultiLineNone {
+		return false
+	}
+	multiLine := strings.Contains(v, "\n")
+	for _, line := range strings.Split(v, "\n") {
+		if t.options.MultiLine == MultiLineBackslash && strings.HasSuffix(line, "\\") {
+			return true
+		}
+		if multiLine && len(line) > 0 && (isIgnoredSpace(line[0]) || isIgnoredSpace(line[len(line)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *TINIFile) multiLineToSave(v string, indent string) string {
+	switch t.options.MultiLine {
+	case MultiLineBackslash:
+		return strings.Replace(v, "\n", "\\\n", -1)
+	case MultiLineIndented:
+		return strings.Replace(v, "\n", "\n"+indent+"\t", -1)
+	}
+	return strings.Replace(v, "\n", " ", -1)
+}
+
+// closingQuote returns the position of the quote closing the one at i or -1.
+func (t *TINIFile) closingQuote(line string, i int) int {
+	for j := i + 1; j < len(line); j++ {
+		if line[j] == '\\' && !t.options.RawValues {
+			j++
+		} else if line[j] == line[i] {
+			return j
+		}
+	}
+	return -1
+}
+
+// processLine parses the line and registers its section in the file.
 func (t *TINIFile) processLine(line string, prevLine _TLine) _TLine {
+	r := t.parseLine(line)
+
+	switch r.Mode {
+	case SECTION:
+		sectionKey := t.sectionKey(r.Section)
+		sec := t.getSection(sectionKey)
+		if sec == nil {
+			t.addSection(_TSection{
+				Section: sectionKey,
+				Name:    r.Section,
+				Begin:   len(t.lines) + 1,
+				End:     len(t.lines) + 1,
+			})
+		} else {
+			sec.End = len(t.lines) + 1
+		}
+	case KEY:
+		r.Section = prevLine.Section
+		if sec := t.getSection(t.sectionKey(r.Section)); sec != nil {
+			sec.End = len(t.lines) + 1
+		}
+	default:
+		r.Section = prevLine.Section
+	}
+
+	if t.options.Debug {
+		fmt.Println("Line analyzed: ", string(line))
+		fmt.Println("Line information: ", r)
+	}
+
+	return r
+}
+
+// parseLine splits the line without allocating, the section, key and value
+// are parts of the line. Only section lines have Section.
+func (t *TINIFile) parseLine(line string) _TLine {
 	r := _TLine{
-		Mode:    IGNORED,
-		Section: prevLine.Section,
-		Line:    line,
+		Mode: IGNORED,
+		Line: line,
 	}
 	ignoringBeginning := true
-	possibleComment := false
-	ignoringComment := false
+	possibleComment := true // the beginning of the line works as a space
 	possibleQuoting := false
 	endingQuoting := 0
 	capturingSection := false
 	capturingKey := false
 	capturingValue := false
-	tempReading := []byte{}
+	reading := -1 // beginning of the text read, -1 if nothing was read
 
-	if len(line) == 0 {
-		ignoringBeginning = true
-	} else {
-		for i := range line {
-			if t.options.Debug {
-				flagsStr := ""
-				if ignoringBeginning {
-					flagsStr += "ignoringBeginning "
-				}
-				if ignoringComment {
-					flagsStr += "ignoringComment "
-				}
-				if capturingSection {
-					flagsStr += "capturingSection "
-				}
-				if capturingKey {
-					flagsStr += "capturingKey "
-				}
-				if capturingValue {
-					flagsStr += "capturingValue "
-				}
-				if possibleComment {
-					flagsStr += "possibleComment "
-				}
-				if possibleQuoting {
-					flagsStr += "possibleQuoting "
-				}
-				if endingQuoting > 0 {
-					flagsStr += fmt.Sprintf("endingQuoting(%d) ", endingQuoting)
-				}
-				if len(flagsStr) > 0 {
-					flagsStr = flagsStr[:len(flagsStr)-1] // remove last space
-				}
-				fmt.Println(fmt.Sprintf("Previous flags: (%s) - Current character: %s", flagsStr, string(line[i])))
+	for i := 0; i < len(line); i++ {
+		if t.options.Debug {
+			flagsStr := ""
+			if ignoringBeginning {
+				flagsStr += "ignoringBeginning "
+			}
+			if capturingSection {
+				flagsStr += "capturingSection "
+			}
+			if capturingKey {
+				flagsStr += "capturingKey "
+			}
+			if capturingValue {
+				flagsStr += "capturingValue "
+			}
+			if possibleComment {
+				flagsStr += "possibleComment "
+			}
+			if possibleQuoting {
+				flagsStr += "possibleQuoting "
+			}
+			if endingQuoting > 0 {
+				flagsStr += fmt.Sprintf("endingQuoting(%d) ", endingQuoting)
+			}
+			if len(flagsStr) > 0 {
+				flagsStr = flagsStr[:len(flagsStr)-1] // remove last space
 			}
+			fmt.Println(fmt.Sprintf("Previous flags: (%s) - Current character: %s", flagsStr, string(line[i])))
+		}
 
-			if ignoringBeginning && !bytes.Contains(_IgnoredSpaces, []byte{byte(line[i])}) {
-				ignoringBeginning = false
-				capturingKey = true
+		if ignoringBeginning {
+			if isIgnoredSpace(line[i]) {
+				continue
 			}
+			ignoringBeginning = false
+			capturingKey = true
+		}
 
-			if !ignoringBeginning {
-				if !ignoringComment && !possibleQuoting &&
-					possibleComment && bytes.Contains(_FlagComments, []byte{byte(line[i])}) {
-					isComment := true
-					possibleComment = false
-					if byte(line[i]) == 47 && len(line) > i { // 47 special
-						if byte(line[i+1]) != 47 {
-							isComment = false
-						}
-					}
-					if isComment {
-						ignoringComment = true
-						capturingKey = false
-						if t.options.Debug {
-							fmt.Println("Ignoring Comments")
-						}
-						break
-					}
-				}
+		if capturingValue && len(r.Value) == 0 && !possibleQuoting && isQuote(line[i]) {
+			// a quote at the beginning of the value wins over a comment
+			endingQuoting = t.closingQuote(line, i)
+			possibleQuoting = endingQuoting > i
+		}
 
-				if (capturingSection || capturingKey) &&
-					!capturingValue && bytes.Contains(_IgnoredSpaces, []byte{byte(line[i])}) {
-					capturingSection = false
-					capturingKey = false
-					if t.options.Debug {
-						fmt.Println("End of key")
-					}
-					break
+		if !possibleQuoting && possibleComment && (reading < 0 || !t.options.NoInlineComments) {
+			possibleComment = false
+			if t.commentAt(line[i:]) {
+				if t.options.Debug {
+					fmt.Println("Ignoring Comments")
 				}
+				break
+			}
+		}
 
-				if !capturingSection &&
-					_Section[0] == byte(line[i]) &&
-					!capturingValue {
-					capturingSection = true
-					capturingKey = false
-
-					if t.options.Debug {
-						fmt.Println("Start of section")
-					}
-					continue
-				} else if capturingSection && _Section[1] == byte(line[i]) {
-					r.Mode = SECTION
-					r.Section = string(tempReading)
-					r.Key = ""
-					r.Value = ""
-
-					sectionKey := string(tempReading)
-					if !t.options.CaseSensitive {
-						sectionKey = strings.ToUpper(sectionKey)
-					}
-
-					sec := t.getSection(sectionKey)
-					if sec == nil {
-						t.sections = append(t.sections, _TSection{
-							Section: sectionKey,
-							Begin:   len(t.lines) + 1,
-							End:     len(t.lines) + 1,
-						})
-					} else {
-						sec.End = len(t.lines) + 1
-					}
+		if !capturingSection && _Section[0] == line[i] && !capturingValue && reading < 0 {
+			capturingSection = true
+			capturingKey = false
+			if t.options.Debug {
+				fmt.Println("Start of section")
+			}
+			continue
+		} else if capturingSection && _Section[1] == line[i] {
+			r.Mode = SECTION
+			if reading >= 0 {
+				r.Section = strings.TrimSpace(line[reading:i])
+			}
+			if t.options.Debug {
+				fmt.Println("End of section")
+			}
+			break
+		}
 
-					capturingSection = false
-					if t.options.Debug {
-						fmt.Println("End of section")
-					}
-					break
+		if capturingKey {
+			if delimiter := t.delimiterAt(line[i:]); delimiter > 0 {
+				r.Mode = KEY
+				if reading >= 0 {
+					r.Key = strings.TrimSpace(line[reading:i])
 				}
-
-				if capturingKey && _KeyValueDiff == byte(line[i]) {
-					r.Mode = KEY
-					r.Section = prevLine.Section
-					r.Key = string(tempReading)
-					r.Value = ""
-					tempReading = []byte{}
-					capturingValue = true
-
-					sectionKey := string(prevLine.Section)
-					if !t.options.CaseSensitive {
-						sectionKey = strings.ToUpper(sectionKey)
-					}
-
-					sec := t.getSection(sectionKey)
-					if sec != nil {
-						sec.End = len(t.lines) + 1
-					}
-
-					if t.options.Debug {
-						fmt.Println("Start of key")
-					}
-					capturingKey = false
-					continue
+				r.ValueBegin = i + delimiter
+				r.ValueEnd = i + delimiter
+				i += delimiter - 1
+				reading = -1
+				capturingKey = false
+				capturingValue = true
+				possibleComment = false
+				if t.options.Debug {
+					fmt.Println("Start of key")
 				}
+				continue
+			}
+		}
 
-				if !ignoringComment {
-					tempReading = append(tempReading, byte(line[i]))
-					if bytes.Contains(_IgnoredSpaces, []byte{byte(line[i])}) && // 9 tab
-						!possibleQuoting {
-						possibleComment = true
-					} else {
-						possibleComment = false
-					}
-					if _FlagQuoting == byte(line[i]) &&
-						len(r.Value) == 0 {
-						endingQuoting = strings.LastIndex(string(line[i:]), string(_FlagQuoting))
-						if endingQuoting != i {
-							possibleQuoting = true
-						}
-					} else if endingQuoting == i {
-						endingQuoting = 0
-						possibleQuoting = false
-					}
-					if capturingValue {
-						r.Value = strings.TrimSpace(string(tempReading))
-					}
-				}
+		if reading < 0 {
+			reading = i
+		}
+		possibleComment = isIgnoredSpace(line[i]) && !possibleQuoting
+		if possibleQuoting && endingQuoting == i {
+			endingQuoting = 0
+			possibleQuoting = false
+		}
+		if capturingValue && !isIgnoredSpace(line[i]) {
+			if len(r.Value) == 0 {
+				r.ValueBegin = i
 			}
+			r.ValueEnd = i + 1
+			r.Value = line[r.ValueBegin:r.ValueEnd]
 		}
 	}
 
-	if t.options.Debug {
-		fmt.Println("Line analyzed: ", string(line))
-		fmt.Println("Line information: ", r)
+	return r
+}
+
+func isIgnoredSpace(c byte) bool {
+	for _, space := range _IgnoredSpaces {
+		if c == space {
+			return true
+		}
 	}
+	return false
+}
 
-	return r
+func isQuote(c byte) bool {
+	for _, quote := range _Quotes {
+		if c == quote {
+			return true
+		}
+	}
+	return false
 }
 
 func (t *TINIFile) getSection(sectionKey string) *_TSection {
-	for i := range t.sections {
-		if t.sections[i].Section == sectionKey {
-			return &t.sections[i]
-		}
+	if i, ok := t.sectionIndex[sectionKey]; ok {
+		return &t.sections[i]
 	}
 
 	return nil
 }
 
 func (t *TINIFile) Set(section string, key string, value TValue) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.own()
+	t.set(section, key, value, "", false)
+}
+
+// set changes or creates the key, comment is written as # lines above the key
+// when it has to be created. An empty value only creates the key with
+// createEmpty, Set keeps not creating them. With DuplicateList every item of
+// an array is a key, the existing ones are changed in place.
+func (t *TINIFile) set(section string, key string, value TValue, comment string, createEmpty bool) {
 	sectionKey := section
 	if !t.options.CaseSensitive {
 		sectionKey = strings.ToUpper(sectionKey)
 	}
 
+	values := []TValue{value}
+	if t.options.DuplicatePolicy == DuplicateList && len(value.array) > 0 {
+		values = make([]TValue, len(value.array))
+		for n := range value.array {
+			values[n] = TValue{Value: []byte(value.array[n])}
+		}
+	}
+
 	// Check if section does not exist, if so, create it
 	sec := t.getSection(sectionKey)
 	if sec == nil {
+		newLines := t.commentLines(section, comment)
+		for _, v := range values {
+			saved := t.valueToSave(v, "")
+			if t.options.Debug {
+				fmt.Println(fmt.Sprintf("Creating section [%s] with key [%s] and value [%s]", section, key, saved))
+			}
+			newLines = append(newLines, t.keyLine(section, key, saved))
+		}
+		t.appendSection(section, newLines)
+		return
+	}
+
+	// if section exists, check if key exists, if so, change value
+	lines := []int{}
+	if t.options.DuplicatePolicy == DuplicateList {
+		lines = t.findKeys(section, key)
+	} else if i := t.findKey(section, key); i >= 0 {
+		lines = append(lines, i)
+	}
+	if len(lines) > len(values) {
 		if t.options.Debug {
-			fmt.Println(fmt.Sprintf("Creating section [%s] with key [%s] and value [%s]", section, key, string(ValueToSave(value.Value, t.options.ForceSaveWithoutQuotes))))
+			fmt.Println(fmt.Sprintf("Removing %d repeated keys [%s] in section [%s]", len(lines)-len(values), key, section))
 		}
+		t.removeLines(lines[len(values):])
+		lines = lines[:len(values)]
+	}
+	for n, i := range lines {
+		t.setLine(section, key, i, values[n])
+	}
 
-		t.sections = append(t.sections, _TSection{
-			Section: sectionKey,
-			Begin:   len(t.lines) + 1,
-			End:     len(t.lines) + 2,
-		})
+	// if section exists, check if key exists, if not, create it
+	if len(lines) == len(values) || (len(lines) == 0 && len(value.Value) == 0 && !createEmpty) {
+		return
+	}
+	newLines := []_TLine{}
+	if len(lines) == 0 {
+		newLines = t.commentLines(section, comment)
+	}
+	for _, v := range values[len(lines):] {
+		saved := t.valueToSave(v, "")
+		if t.options.Debug {
+			fmt.Println(fmt.Sprintf("Creating key [%s] in section [%s] with value [%s]", key, section, saved))
+		}
+		newLines = append(newLines, t.keyLine(section, key, saved))
+	}
+	t.insertLines(sectionKey, newLines)
+}
 
-		newLines := []_TLine{
-			{
-				Mode: IGNORED, // empty line
-			},
-			{
-				Mode:    SECTION,
-				Section: section,
-				Line:    string(_Section[0]) + section + string(_Section[1]),
-			},
-			{
-				Mode:    KEY,
-				Section: section,
-				Key:     key,
-				Value:   string(ValueToSave(value.Value, t.options.ForceSaveWithoutQuotes)),
-				Line:    key + string(_KeyValueDiff) + string(ValueToSave(value.Value, t.options.ForceSaveWithoutQuotes)),
-			},
-		}
-		t.lines = append(t.lines, newLines...)
-		(*t).lines = t.lines
+// setLine changes the value of the key line i, the spaces and the comment
+// around it are kept.
+func (t *TINIFile) setLine(section string, key string, i int, value TValue) {
+	prevLine := t.lines[i]
+	line := t.lines[i].Line
+	indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+	saved := t.valueToSave(value, indent)
+	if t.lines[i].Value == saved {
+		if t.options.Debug {
+			fmt.Println(fmt.Sprintf("Ignoring value of key [%s] in section [%s], value is the same: [%s]", key, section, t.lines[i].Value))
+		}
 		return
 	}
 
-	// if section exists, check if key exists, if so, change value
-	var prevLine _TLine
-	for i := sec.Begin; i <= sec.End && i < len(t.lines); i++ {
-		if t.lines[i].Mode == KEY {
-			prevLine = t.lines[i]
-			if (!t.options.CaseSensitive && strings.EqualFold(t.lines[i].Key, key)) ||
-				(t.options.CaseSensitive && t.lines[i].Key == key) {
-				if t.lines[i].Value == string(ValueToSave(value.Value, t.options.ForceSaveWithoutQuotes)) {
-					if t.options.Debug {
-						fmt.Println(fmt.Sprintf("Ignoring value of key [%s] in section [%s], value is the same: [%s]", key, section, t.lines[i].Value))
-					}
-					return
-				}
+	if t.options.Debug {
+		fmt.Println(fmt.Sprintf("Changing value of key [%s] in section [%s], previous value: [%s], new value: [%s]", key, section, t.lines[i].Value, saved))
+	}
 
-				if t.options.Debug {
-					fmt.Println(fmt.Sprintf("Changing value of key [%s] in section [%s], previous value: [%s], new value: [%s]", key, section, t.lines[i].Value, string(ValueToSave(value.Value, t.options.ForceSaveWithoutQuotes))))
-				}
+	tempKey := t.lines[i].Line[:t.lines[i].ValueBegin]
+	tempNonValue := t.lines[i].Line[t.lines[i].ValueEnd:]
 
-				key = t.lines[i].Key
-				tempKey := []byte(t.lines[i].Line[:strings.Index(t.lines[i].Line, key)+len(key+string(_KeyValueDiff))])
-				tempRest := []byte(t.lines[i].Line[len(tempKey):])
-				tempNonValue := []byte{}
-				if len(t.lines[i].Value)+1 < len(tempRest) {
-					tempNonValue = append([]byte{32}, tempRest[len(t.lines[i].Value)+1:]...)
-				}
+	(*t).lines[i].Value = saved
+	(*t).lines[i].Line = tempKey + t.lines[i].Value + tempNonValue
+	(*t).lines[i].ValueEnd = t.lines[i].ValueBegin + len(t.lines[i].Value)
+	if t.options.Debug {
+		fmt.Println(fmt.Sprintf("Line changed, previous line: [%s], new line: [%s]", prevLine.Line, t.lines[i].Line))
+	}
+}
 
-				(*t).lines[i].Value = string(ValueToSave(value.Value, t.options.ForceSaveWithoutQuotes))
-				(*t).lines[i].Line = string(tempKey) + t.lines[i].Value + string(tempNonValue)
-				if t.options.Debug {
-					fmt.Println(fmt.Sprintf("Line changed, previous line: [%s], new line: [%s]", prevLine.Line, t.lines[i].Line))
-				}
-				return
+// insertLines adds the lines at the end of the section, moving the following
+// sections.
+func (t *TINIFile) insertLines(sectionKey string, newLines []_TLine) {
+	sec := t.getSection(sectionKey)
+	if sec == nil {
+		return
+	}
+	at := sec.End
+	if sectionKey == "" && !t.hasKeys(sec) {
+		// the global section has no keys yet, new keys go before the first
+		// section header, its comments and the empty lines before them
+		at = len(t.lines)
+		for i := range t.lines {
+			if t.lines[i].Mode == SECTION {
+				at = i
+				break
 			}
 		}
+		if block := t.commentBlock(at); len(block) > 0 {
+			at = block[0]
+		}
+		for at > 0 && at <= len(t.lines) && len(strings.TrimSpace(t.lines[at-1].Line)) == 0 {
+			at--
+		}
+		sec.Begin = at
+		sec.End = at
+	}
+	sec.End += len(newLines)
+	// a repeated section spans the sections between, they move by position
+	for i := range t.sections {
+		if &t.sections[i] == sec {
+			continue
+		}
+		if t.sections[i].Begin >= at {
+			t.sections[i].Begin += len(newLines)
+		}
+		if t.sections[i].End > at || t.sections[i].Begin >= at {
+			t.sections[i].End += len(newLines)
+		}
 	}
 
-	// if section exists, check if key exists, if not, create it
-	if len(value.Value) > 0 {
-		if t.options.Debug {
-			fmt.Println(fmt.Sprintf("Creating key [%s] in section [%s] with value [%s]", key, section, string(ValueToSave(value.Value, t.options.ForceSaveWithoutQuotes))))
+	t.shiftKeys(at, len(newLines))
+	t.lines = append(t.lines[:at], append(newLines, t.lines[at:]...)...)
+	for i := at; i < at+len(newLines); i++ {
+		t.indexLine(i)
+	}
+}
+
+func (t *TINIFile) hasKeys(sec *_TSection) bool {
+	for i := sec.Begin; i < sec.End; i++ {
+		if t.lines[i].Mode == KEY {
+			return true
 		}
+	}
+	return false
+}
 
-		sec := t.getSection(sectionKey)
-		if sec == nil {
-			return
+// validName returns if the key or section name is read back as written, it
+// can not be empty, have spaces around it, begin with [ or have a delimiter,
+// a comment prefix, a ] or a line break.
+func (t *TINIFile) validName(name string) bool {
+	if len(name) == 0 || strings.TrimSpace(name) != name || name[0] == _Section[0] ||
+		strings.ContainsAny(name, string(_Section[1])+"\r\n") {
+		return false
+	}
+	for i := range name {
+		if t.commentAt(name[i:]) || t.delimiterAt(name[i:]) > 0 {
+			return false
 		}
-		sec.End++
-		moving := false
-		for i := range t.sections {
-			if t.sections[i].Section == sectionKey {
-				moving = true
-			} else if moving {
-				t.sections[i].Begin++
-				t.sections[i].End++
+	}
+	return true
+}
+
+// RenameKey changes the name of the key keeping its value and comments, it
+// fails if the key does not exist, the new name is already used or it is not
+// a valid name.
+func (t *TINIFile) RenameKey(section string, oldKey string, newKey string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.own()
+	i := t.findKey(section, oldKey)
+	if i < 0 || !t.validName(newKey) {
+		return false
+	}
+	if j := t.findKey(section, newKey); j >= 0 && j != i {
+		return false
+	}
+	if t.options.Debug {
+		fmt.Println(fmt.Sprintf("Renaming key [%s] in section [%s] to [%s]", oldKey, section, newKey))
+	}
+
+	t.unindexLine(i)
+	defer t.indexLine(i)
+	at := strings.Index(t.lines[i].Line, t.lines[i].Key)
+	t.lines[i].Line = t.lines[i].Line[:at] + newKey + t.lines[i].Line[at+len(t.lines[i].Key):]
+	t.lines[i].ValueBegin += len(newKey) - len(t.lines[i].Key)
+	t.lines[i].ValueEnd += len(newKey) - len(t.lines[i].Key)
+	t.lines[i].Key = newKey
+	return true
+}
+
+// RenameSection changes the name of the section keeping its keys and
+// comments, it fails if the section does not exist, the new name is already
+// used or it is not a valid name.
+func (t *TINIFile) RenameSection(oldSection string, newSection string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.own()
+	sec := t.getSection(t.sectionKey(oldSection))
+	if sec == nil || oldSection == "" || !t.validName(newSection) {
+		return false
+	}
+	if t.sectionKey(newSection) != sec.Section && t.getSection(t.sectionKey(newSection)) != nil {
+		return false
+	}
+	if t.options.Debug {
+		fmt.Println(fmt.Sprintf("Renaming section [%s] to [%s]", oldSection, newSection))
+	}
+
+	for i := range t.lines {
+		if (!t.options.CaseSensitive && strings.EqualFold(t.lines[i].Section, oldSection)) ||
+			(t.options.CaseSensitive && t.lines[i].Section == oldSection) {
+			if t.lines[i].Mode == SECTION {
+				begin := strings.IndexByte(t.lines[i].Line, _Section[0]) + 1
+				at := begin + strings.Index(t.lines[i].Line[begin:], t.lines[i].Section)
+				t.lines[i].Line = t.lines[i].Line[:at] + newSection + t.lines[i].Line[at+len(t.lines[i].Section):]
 			}
+			t.lines[i].Section = newSection
 		}
+	}
+	sec.Section = t.sectionKey(newSection)
+	sec.Name = newSection
+	t.indexSections()
+	t.indexKeys()
+	return true
+}
+
+// MoveKey moves the key with the comment lines above it to the end of another
+// section, creating it if needed. It fails if the key does not exist or the
+// other section already has it, moving it to its own section does nothing.
+func (t *TINIFile) MoveKey(fromSection string, toSection string, key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.own()
+	i := t.findKey(fromSection, key)
+	if i < 0 {
+		return false
+	}
+	if t.sectionKey(fromSection) == t.sectionKey(toSection) {
+		return true
+	}
+	if t.findKey(toSection, key) >= 0 {
+		return false
+	}
+	if t.options.Debug {
+		fmt.Println(fmt.Sprintf("Moving key [%s] from section [%s] to [%s]", key, fromSection, toSection))
+	}
+
+	indexes := append(t.commentBlock(i), i)
+	moved := make([]_TLine, len(indexes))
+	for n := range indexes {
+		moved[n] = t.lines[indexes[n]]
+		moved[n].Section = toSection
+		moved[n].Number = 0
+	}
+	t.removeLines(indexes)
+
+	if t.getSection(t.sectionKey(toSection)) == nil {
+		t.appendSection(toSection, moved)
+	} else {
+		t.insertLines(t.sectionKey(toSection), moved)
+	}
+	return true
+}
 
-		newLine := _TLine{
-			Mode:    KEY,
+// appendSection adds the section at the end of the file with the lines.
+func (t *TINIFile) appendSection(section string, sectionLines []_TLine) {
+	newLines := []_TLine{
+		{
+			Mode: IGNORED, // empty line
+		},
+		{
+			Mode:    SECTION,
 			Section: section,
-			Key:     key,
-			Value:   string(ValueToSave(value.Value, t.options.ForceSaveWithoutQuotes)),
-			Line:    key + string(_KeyValueDiff) + string(ValueToSave(value.Value, t.options.ForceSaveWithoutQuotes)),
+			Line:    string(_Section[0]) + section + string(_Section[1]),
+		},
+	}
+	newLines = append(newLines, sectionLines...)
+
+	// Begin is the line after the header, End the line after the last key
+	t.addSection(_TSection{
+		Section: t.sectionKey(section),
+		Name:    section,
+		Begin:   len(t.lines) + 2,
+		End:     len(t.lines) + len(newLines),
+	})
+	t.lines = append(t.lines, newLines...)
+	for i := len(t.lines) - len(sectionLines); i < len(t.lines); i++ {
+		t.indexLine(i)
+	}
+}
+
+// removeLines removes the lines at the indexes, moving the sections that
+// follow them.
+func (t *TINIFile) removeLines(indexes []int) {
+	if len(indexes) == 0 {
+		return
+	}
+	sort.Ints(indexes)
+	unique := indexes[:1]
+	for _, i := range indexes[1:] {
+		if i != unique[len(unique)-1] {
+			unique = append(unique, i)
+		}
+	}
+	indexes = unique
+
+	moved := func(pos int) int {
+		removed := 0
+		for _, i := range indexes {
+			if i < pos {
+				removed++
+			}
+		}
+		return pos - removed
+	}
+	for i := range t.sections {
+		t.sections[i].Begin = moved(t.sections[i].Begin)
+		t.sections[i].End = moved(t.sections[i].End)
+	}
+
+	lines := t.lines[:indexes[0]]
+	for n, i := range indexes {
+		next := len(t.lines)
+		if n+1 < len(indexes) {
+			next = indexes[n+1]
+		}
+		lines = append(lines, t.lines[i+1:next]...)
+	}
+	t.lines = lines
+	t.indexKeys()
+}
+
+// commentBlock returns the indexes of the comment lines right above the line.
+func (t *TINIFile) commentBlock(line int) []int {
+	indexes := []int{}
+	for i := line - 1; i >= 0 && t.lines[i].Mode == IGNORED && t.commentAt(strings.TrimSpace(t.lines[i].Line)); i-- {
+		indexes = append([]int{i}, indexes...)
+	}
+	return indexes
+}
+
+// DeleteKey removes the key and returns if it existed, withComment removes
+// the comment lines right above it too. With DuplicateList every occurrence
+// is removed.
+func (t *TINIFile) DeleteKey(section string, key string, withComment bool) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.own()
+	i := t.findKey(section, key)
+	if i < 0 {
+		return false
+	}
+	if t.options.Debug {
+		fmt.Println(fmt.Sprintf("Deleting key [%s] in section [%s]", key, section))
+	}
+	lines := []int{i}
+	if t.options.DuplicatePolicy == DuplicateList {
+		lines = t.findKeys(section, key)
+	}
+	indexes := []int{}
+	for _, line := range lines {
+		if withComment {
+			indexes = append(indexes, t.commentBlock(line)...)
+		}
+		indexes = append(indexes, line)
+	}
+	t.removeLines(indexes)
+	return true
+}
+
+// DeleteSection removes the section with all its keys and returns if it
+// existed, withComment removes the comment lines right above the header too.
+func (t *TINIFile) DeleteSection(section string, withComment bool) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.own()
+	sectionKey := t.sectionKey(section)
+	pos := -1
+	for i := range t.sections {
+		if t.sections[i].Section == sectionKey {
+			pos = i
+		}
+	}
+	if pos < 0 {
+		return false
+	}
+	if t.options.Debug {
+		fmt.Println(fmt.Sprintf("Deleting section [%s]", section))
+	}
+
+	// the global section has no header, only its keys are removed
+	if sectionKey == "" {
+		indexes := []int{}
+		for i := t.sections[pos].Begin; i < t.sections[pos].End; i++ {
+			if t.lines[i].Mode == KEY && t.lines[i].Section == "" {
+				if withComment {
+					indexes = append(indexes, t.commentBlock(i)...)
+				}
+				indexes = append(indexes, i)
+			}
+		}
+		t.removeLines(indexes)
+		return len(indexes) > 0
+	}
+
+	// a repeated section spans the sections between, only its lines are removed
+	indexes := []int{}
+	for i := t.sections[pos].Begin - 1; i < t.sections[pos].End; i++ {
+		if (!t.options.CaseSensitive && strings.EqualFold(t.lines[i].Section, section)) ||
+			(t.options.CaseSensitive && t.lines[i].Section == section) {
+			if t.lines[i].Mode == SECTION && withComment {
+				indexes = append(indexes, t.commentBlock(i)...)
+			}
+			indexes = append(indexes, i)
 		}
+	}
+	t.sections = append(t.sections[:pos], t.sections[pos+1:]...)
+	t.indexSections()
+	t.removeLines(indexes)
+	return true
+}
 
-		t.lines = append(t.lines, _TLine{})
-		copy(t.lines[sec.End-1:], t.lines[sec.End-2:])
-		t.lines[sec.End-1] = newLine
-		(*t).lines = t.lines
+func (t *TINIFile) keyLine(section string, key string, value string) _TLine {
+	delimiter := t.delimiters()[0]
+	return _TLine{
+		Mode:       KEY,
+		Section:    section,
+		Key:        key,
+		Value:      value,
+		Line:       key + delimiter + value,
+		ValueBegin: len(key) + len(delimiter),
+		ValueEnd:   len(key) + len(delimiter) + len(value),
 	}
 }
 
+func (t *TINIFile) commentLines(section string, comment string) []_TLine {
+	lines := []_TLine{}
+	if len(comment) == 0 {
+		return lines
+	}
+	for _, c := range strings.Split(comment, "\n") {
+		lines = append(lines, _TLine{
+			Mode:    IGNORED,
+			Section: section,
+			Line:    strings.TrimSpace(t.commentPrefixes()[0] + " " + c),
+		})
+	}
+	return lines
+}
+
 func (t *TINIFile) Get(section string, key string) TValue {
-	sectionKey := section
+	v, _ := t.Lookup(section, key)
+	return v
+}
+
+// Lookup returns the value of the key and if it exists, an empty value
+// exists too. With EnvPrefix an environment variable wins over the file.
+func (t *TINIFile) Lookup(section string, key string) (TValue, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.lookup(section, key)
+}
+
+func (t *TINIFile) lookup(section string, key string) (TValue, bool) {
+	if v, ok := t.lookupEnv(section, key); ok {
+		return v, true
+	}
+	if t.options.DuplicatePolicy == DuplicateList {
+		values := t.getAll(section, key)
+		if len(values) < 2 {
+			if len(values) == 0 {
+				return TValue{section: section, key: key}, false
+			}
+			// a single occurrence is a list of one value like the repeated ones
+			values[0].array = []string{string(values[0].Value)}
+			return values[0], true
+		}
+		items := make([]string, len(values))
+		for i := range values {
+			items[i] = string(values[i].Value)
+		}
+		return TValue{
+			Value:     []byte(strings.Join(items, t.arraySeparator())),
+			section:   section,
+			key:       key,
+			separator: t.arraySeparator(),
+			array:     items,
+		}, true
+	}
+	i := t.findKey(section, key)
+	if i < 0 {
+		return TValue{section: section, key: key}, false
+	}
+	return t.lineValue(section, key, i), true
+}
+
+// GetAll returns the value of every occurrence of the key in file order,
+// whatever the DuplicatePolicy. With EnvPrefix an environment variable
+// replaces them like in Get.
+func (t *TINIFile) GetAll(section string, key string) []TValue {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if v, ok := t.lookupEnv(section, key); ok {
+		return []TValue{v}
+	}
+	return t.getAll(section, key)
+}
+
+func (t *TINIFile) getAll(section string, key string) []TValue {
+	values := []TValue{}
+	for _, i := range t.findKeys(section, key) {
+		values = append(values, t.lineValue(section, key, i))
+	}
+	return values
+}
+
+func (t *TINIFile) lineValue(section string, key string, i int) TValue {
+	return TValue{
+		Value:     []byte(t.valueToRead(t.lines[i].Value)),
+		section:   section,
+		key:       key,
+		separator: t.arraySeparator(),
+	}
+}
+
+// findKey returns the index of the line of the key or -1, the last one with
+// DuplicateLast.
+func (t *TINIFile) findKey(section string, key string) int {
+	lines := t.keyLines(section, key)
+	if len(lines) == 0 {
+		return -1
+	}
+	if t.options.DuplicatePolicy == DuplicateLast {
+		return lines[len(lines)-1]
+	}
+	return lines[0]
+}
+
+// findKeys returns the indexes of the lines of every occurrence of the key.
+func (t *TINIFile) findKeys(section string, key string) []int {
+	return append([]int{}, t.keyLines(section, key)...)
+}
+
+func (t *TINIFile) sectionKey(section string) string {
 	if !t.options.CaseSensitive {
-		sectionKey = strings.ToUpper(sectionKey)
+		return strings.ToUpper(section)
 	}
+	return section
+}
 
-	sec := t.getSection(sectionKey)
+// Sections returns the names of the sections in file order, the global
+// section is the first one as "" when it has keys.
+func (t *TINIFile) Sections() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.sectionNames()
+}
+
+func (t *TINIFile) sectionNames() []string {
+	names := []string{}
+	for i := range t.sections {
+		if t.sections[i].Section != "" || t.hasKeys(&t.sections[i]) {
+			names = append(names, t.sections[i].Name)
+		}
+	}
+	return names
+}
+
+// Keys returns the keys of the section in file order.
+func (t *TINIFile) Keys(section string) []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.keys(section)
+}
+
+func (t *TINIFile) keys(section string) []string {
+	keys := []string{}
+	sec := t.getSection(t.sectionKey(section))
 	if sec == nil {
-		return TValue{}
+		return keys
 	}
+	seen := map[string]bool{}
 	for i := sec.Begin; i < sec.End; i++ {
+		if t.lines[i].Mode != KEY {
+			continue
+		}
 		if (!t.options.CaseSensitive && strings.EqualFold(t.lines[i].Section, section)) ||
 			(t.options.CaseSensitive && t.lines[i].Section == section) {
-			if (!t.options.CaseSensitive && strings.EqualFold(t.lines[i].Key, key)) ||
-				(t.options.CaseSensitive && t.lines[i].Key == key) {
-				return TValue{
-					Value: []byte(t.lines[i].Value),
-				}
+			if !seen[t.sectionKey(t.lines[i].Key)] {
+				seen[t.sectionKey(t.lines[i].Key)] = true
+				keys = append(keys, t.lines[i].Key)
 			}
 		}
 	}
+	return keys
+}
+
+// Range calls fn for every key in file order until it returns false, fn is
+// called with the values read before the first call so it can change the
+// file.
+func (t *TINIFile) Range(fn func(section string, key string, value TValue) bool) {
+	type entry struct {
+		section string
+		key     string
+		value   TValue
+	}
+	entries := []entry{}
+	t.mu.RLock()
+	for _, section := range t.sectionNames() {
+		for _, key := range t.keys(section) {
+			value, _ := t.lookup(section, key)
+			entries = append(entries, entry{section, key, value})
+		}
+	}
+	t.mu.RUnlock()
+
+	for _, e := range entries {
+		if !fn(e.section, e.key, e.value) {
+			return
+		}
+	}
+}
 
-	return TValue{}
+func (t *TINIFile) Has(section string, key string) bool {
+	_, ok := t.Lookup(section, key)
+	return ok
 }
 
-func ValueToSave(value []byte, forceWithoutQuotes bool) []byte {
-	if !forceWithoutQuotes {
-		flagQuote := false
-		for i := range value {
-			if value[i] == '\n' {
-				value[i] = ' '
-			} else if bytes.Contains(_FlagComments, []byte{byte(value[i])}) {
-				flagQuote = true
-			}
+// Defaults, returned when the key is missing or the value is invalid
+
+func (t *TINIFile) GetStringOr(section string, key string, def string) string {
+	if v, ok := t.Lookup(section, key); ok {
+		return v.String()
+	}
+	return def
+}
+
+func (t *TINIFile) GetBoolOr(section string, key string, def bool) bool {
+	if v, ok := t.Lookup(section, key); ok {
+		if b, err := v.BoolE(); err == nil {
+			return b
 		}
+	}
+	return def
+}
 
-		if flagQuote && len(value) > 0 {
-			if value[0] != _FlagQuoting {
-				value = append([]byte{_FlagQuoting}, value...)
-				value = append(value, _FlagQuoting)
-			}
+func (t *TINIFile) GetIntOr(section string, key string, def int) int {
+	if v, ok := t.Lookup(section, key); ok {
+		if i, err := v.IntE(); err == nil {
+			return i
+		}
+	}
+	return def
+}
+
+func (t *TINIFile) GetInt64Or(section string, key string, def int64) int64 {
+	if v, ok := t.Lookup(section, key); ok {
+		if i, err := v.Int64E(); err == nil {
+			return i
 		}
 	}
+	return def
+}
 
-	return value
+func (t *TINIFile) GetUint64Or(section string, key string, def uint64) uint64 {
+	if v, ok := t.Lookup(section, key); ok {
+		if i, err := v.Uint64E(); err == nil {
+			return i
+		}
+	}
+	return def
 }
 
-func ValueToRead(value []byte) []byte {
-	if len(value) > 0 {
-		if value[0] == _FlagQuoting &&
-			value[len(value)-1] == _FlagQuoting {
-			value = value[1 : len(value)-1]
+func (t *TINIFile) GetFloat64Or(section string, key string, def float64) float64 {
+	if v, ok := t.Lookup(section, key); ok {
+		if f, err := v.Float64E(); err == nil {
+			return f
 		}
 	}
+	return def
+}
 
-	return value
+func (t *TINIFile) GetDurationOr(section string, key string, def time.Duration) time.Duration {
+	if v, ok := t.Lookup(section, key); ok {
+		if d, err := v.DurationE(); err == nil {
+			return d
+		}
+	}
+	return def
+}
+
+func ValueToSave(value []byte, forceWithoutQuotes bool) []byte {
+	t := New(&TOptions{ForceSaveWithoutQuotes: forceWithoutQuotes})
+	return []byte(t.valueToSave(TValue{Value: value}, ""))
+}
+
+func ValueToRead(value []byte) []byte {
+	return []byte(unquoteValue(string(value), false))
 }
@@ -1,9 +1,16 @@
 package goini
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 type TestValue struct {
@@ -199,3 +206,1620 @@ func TestSpecial2(t *testing.T) {
 		t.Errorf("Expected Never change this, got %s", ini.Get("Test", "same").String())
 	}
 }
+
+var sourceContent = []byte(`[Server]
+host=localhost
+port=8080 ; comment
+
+[Client]
+retries=3`)
+
+func checkSourceContent(t *testing.T, ini *TINIFile, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ini.Get("Server", "host").String() != "localhost" {
+		t.Errorf("Expected localhost, got %s", ini.Get("Server", "host").String())
+	}
+	if ini.Get("Server", "port").Int() != 8080 {
+		t.Errorf("Expected 8080, got %d", ini.Get("Server", "port").Int())
+	}
+	if ini.Get("Client", "retries").Int() != 3 {
+		t.Errorf("Expected 3, got %d", ini.Get("Client", "retries").Int())
+	}
+}
+
+func TestLoadBytes(t *testing.T) {
+	ini, err := LoadBytes(sourceContent, nil)
+	checkSourceContent(t, ini, err)
+}
+
+func TestLoadReader(t *testing.T) {
+	ini, err := LoadReader(bytes.NewReader(sourceContent), nil)
+	checkSourceContent(t, ini, err)
+}
+
+func TestLoadFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"config/app.ini": &fstest.MapFile{Data: sourceContent},
+	}
+	ini, err := LoadFS(fsys, "config/app.ini", nil)
+	checkSourceContent(t, ini, err)
+	if ini.Filename != "config/app.ini" {
+		t.Errorf("Expected config/app.ini, got %s", ini.Filename)
+	}
+
+	if _, err := LoadFS(fsys, "missing.ini", nil); err == nil {
+		t.Error("Expected error loading a missing file")
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	ini, err := LoadBytes(sourceContent, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ini.Set("Client", "retries", Int(5))
+
+	var buf bytes.Buffer
+	n, err := ini.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("Expected %d bytes written, got %d", buf.Len(), n)
+	}
+	if buf.String() != ini.String() || !bytes.Equal(buf.Bytes(), ini.Bytes()) {
+		t.Errorf("Expected WriteTo, Bytes and String to render the same contents")
+	}
+
+	if !strings.Contains(ini.String(), "retries=5") {
+		t.Errorf("Expected retries=5 in %q", ini.String())
+	}
+}
+
+func TestSaveAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.ini")
+	if err := os.WriteFile(path, sourceContent, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	ini, err := Load(path, &TOptions{AtomicSave: true, Backups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		ini.Set("Client", "retries", Int(3+i))
+		if err := ini.Save(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("Expected mode 0640, got %o", info.Mode().Perm())
+	}
+	saved, _ := os.ReadFile(path)
+	if !bytes.Equal(saved, ini.Bytes()) {
+		t.Errorf("Expected %q, got %q", ini.Bytes(), saved)
+	}
+
+	for name, retries := range map[string]string{"app.ini.bak": "retries=5", "app.ini.bak.1": "retries=4"} {
+		backup, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(backup, []byte(retries)) {
+			t.Errorf("Expected %s in %s, got %q", retries, name, backup)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "app.ini.bak.2")); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 backups")
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Errorf("Expected 3 files, temp files left behind: %v", entries)
+	}
+}
+
+func TestLineEndings(t *testing.T) {
+	for _, content := range []string{
+		"[Test]\nkey=1\n\n# comment\nother=2\n",
+		"[Test]\r\nkey=1\r\n\r\n# comment\r\nother=2\r\n",
+		"[Test]\rkey=1\r\r# comment\rother=2\r",
+		"[Test]\nkey=1\n\n# comment\nother=2",
+	} {
+		ini, err := LoadBytes([]byte(content), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ini.Get("Test", "key").Int() != 1 || ini.Get("Test", "other").Int() != 2 {
+			t.Errorf("Expected 1 and 2, got %d and %d in %q", ini.Get("Test", "key").Int(), ini.Get("Test", "other").Int(), content)
+		}
+		if ini.String() != content {
+			t.Errorf("Expected %q, got %q", content, ini.String())
+		}
+	}
+
+	ini, err := LoadReader(bytes.NewReader([]byte("[Test]\r\nkey=1\r\n")), &TOptions{LineEnding: LineEndingLF})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ini.LineEnding != LineEndingCRLF {
+		t.Errorf("Expected CRLF detected, got %q", ini.LineEnding)
+	}
+	if ini.String() != "[Test]\nkey=1\n" {
+		t.Errorf("Expected LF line endings, got %q", ini.String())
+	}
+}
+
+func TestConversionErrors(t *testing.T) {
+	ini, err := LoadBytes([]byte(`[Server]
+port=80x
+big=300
+negative=-1
+ratio=0.5
+enabled=maybe
+ok=42`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ini.Get("Server", "port").IntE(); err == nil {
+		t.Error("Expected error converting 80x to int")
+	} else if convErr, ok := err.(*ConversionError); !ok {
+		t.Errorf("Expected *ConversionError, got %T", err)
+	} else if convErr.Section != "Server" || convErr.Key != "port" || convErr.Value != "80x" || convErr.Type != "int" || !errors.Is(err, strconv.ErrSyntax) {
+		t.Errorf("Unexpected error information: %+v", convErr)
+	}
+	if ini.Get("Server", "port").Int() != 0 {
+		t.Errorf("Expected 0, got %d", ini.Get("Server", "port").Int())
+	}
+
+	if _, err := ini.Get("Server", "big").ByteE(); !errors.Is(err, strconv.ErrRange) {
+		t.Errorf("Expected range error converting 300 to byte, got %v", err)
+	}
+	if _, err := ini.Get("Server", "big").Int8E(); !errors.Is(err, strconv.ErrRange) {
+		t.Errorf("Expected range error converting 300 to int8, got %v", err)
+	}
+	if v, err := ini.Get("Server", "big").Int16E(); err != nil || v != 300 {
+		t.Errorf("Expected 300, got %d, %v", v, err)
+	}
+	if _, err := ini.Get("Server", "negative").Uint64E(); err == nil {
+		t.Error("Expected error converting -1 to uint64")
+	}
+	if v, err := ini.Get("Server", "ratio").Float64E(); err != nil || v != 0.5 {
+		t.Errorf("Expected 0.5, got %f, %v", v, err)
+	}
+	if _, err := ini.Get("Server", "ratio").Int32E(); err == nil {
+		t.Error("Expected error converting 0.5 to int32")
+	}
+	if _, err := ini.Get("Server", "enabled").BoolE(); err == nil {
+		t.Error("Expected error converting maybe to bool")
+	}
+	if v, err := ini.Get("Server", "ok").Int64E(); err != nil || v != 42 {
+		t.Errorf("Expected 42, got %d, %v", v, err)
+	}
+	if _, err := ini.Get("Server", "missing").IntE(); err == nil {
+		t.Error("Expected error converting a missing key")
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	ini, err := LoadBytes([]byte(`[Server]
+port=80x
+empty=
+timeout=1m30s`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, ok := ini.Lookup("Server", "empty"); !ok || v.String() != "" {
+		t.Errorf("Expected empty key to exist, got %q, %t", v.String(), ok)
+	}
+	if _, ok := ini.Lookup("Server", "missing"); ok {
+		t.Error("Expected missing key to not exist")
+	}
+	if !ini.Has("server", "TIMEOUT") || ini.Has("Client", "timeout") {
+		t.Error("Expected Has to find only [Server] timeout")
+	}
+
+	if ini.GetIntOr("Server", "missing", 8080) != 8080 {
+		t.Errorf("Expected 8080, got %d", ini.GetIntOr("Server", "missing", 8080))
+	}
+	if ini.GetIntOr("Server", "port", 8080) != 8080 {
+		t.Errorf("Expected 8080 for an invalid value, got %d", ini.GetIntOr("Server", "port", 8080))
+	}
+	if ini.GetStringOr("Server", "empty", "default") != "" {
+		t.Errorf("Expected empty string, got %s", ini.GetStringOr("Server", "empty", "default"))
+	}
+	if ini.GetDurationOr("Server", "timeout", time.Second) != 90*time.Second {
+		t.Errorf("Expected 1m30s, got %s", ini.GetDurationOr("Server", "timeout", time.Second))
+	}
+	if ini.GetDurationOr("Client", "timeout", time.Second) != time.Second {
+		t.Errorf("Expected 1s, got %s", ini.GetDurationOr("Client", "timeout", time.Second))
+	}
+
+	ini = New(nil)
+	ini.Set("Server", "port", Int(80))
+	ini.Set("Server", "timeout", Duration(time.Minute))
+	if ini.GetIntOr("Server", "port", 8080) != 80 || ini.GetDurationOr("Server", "timeout", 0) != time.Minute {
+		t.Errorf("Expected 80 and 1m0s, got %s", ini.String())
+	}
+}
+
+func TestDelete(t *testing.T) {
+	content := []byte(`[A]
+# about a1
+a1=1
+a2=2
+
+# about B
+[B]
+b1=1
+# about b2
+b2=2
+
+[C]
+c1=1`)
+
+	ini, err := LoadBytes(content, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ini.DeleteKey("A", "a1", false) || ini.DeleteKey("A", "a1", false) {
+		t.Error("Expected a1 to be deleted once")
+	}
+	if !ini.DeleteKey("b", "B2", true) {
+		t.Error("Expected b2 to be deleted")
+	}
+	if ini.DeleteKey("Missing", "b2", true) {
+		t.Error("Expected missing section to not be deleted")
+	}
+	ini.Set("B", "b3", Int(3))
+	ini.Set("C", "c2", Int(2))
+	expected := `[A]
+# about a1
+a2=2
+
+# about B
+[B]
+b1=1
+b3=3
+
+[C]
+c1=1
+c2=2`
+	if ini.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, ini.String())
+	}
+	if ini.Get("C", "c1").Int() != 1 || ini.Get("C", "c2").Int() != 2 || ini.Get("B", "b3").Int() != 3 {
+		t.Errorf("Expected sections to be moved after the deletes")
+	}
+
+	ini, err = LoadBytes(content, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ini.DeleteSection("B", true) || ini.DeleteSection("B", true) {
+		t.Error("Expected B to be deleted once")
+	}
+	ini.Set("C", "c2", Int(2))
+	expected = `[A]
+# about a1
+a1=1
+a2=2
+
+
+[C]
+c1=1
+c2=2`
+	if ini.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, ini.String())
+	}
+	if ini.Has("B", "b1") || ini.Get("C", "c2").Int() != 2 || ini.Get("A", "a2").Int() != 2 {
+		t.Errorf("Expected sections to be moved after the delete")
+	}
+}
+
+func TestRenameAndMove(t *testing.T) {
+	ini, err := LoadBytes([]byte(`[Old] ; old section
+# about port
+port=80 ; web port
+host=localhost
+
+[Other]
+other=1`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ini.RenameKey("Old", "port", "listen_port") {
+		t.Error("Expected port to be renamed")
+	}
+	if ini.RenameKey("Old", "host", "listen_port") || ini.RenameKey("Old", "missing", "other") {
+		t.Error("Expected rename to an existing or from a missing key to fail")
+	}
+	if !ini.RenameSection("old", "New") || ini.RenameSection("New", "Other") || ini.RenameSection("Old", "Any") {
+		t.Error("Expected only the rename of Old to New to work")
+	}
+	if !ini.MoveKey("New", "Other", "listen_port") || ini.MoveKey("New", "Other", "listen_port") {
+		t.Error("Expected listen_port to be moved once")
+	}
+	if !ini.MoveKey("New", "Created", "host") {
+		t.Error("Expected host to be moved to a new section")
+	}
+	if !ini.MoveKey("Created", "created", "host") || ini.MoveKey("Created", "Created", "missing") {
+		t.Error("Expected a move to the same section to do nothing")
+	}
+
+	expected := `[New] ; old section
+
+[Other]
+other=1
+# about port
+listen_port=80 ; web port
+
+[Created]
+host=localhost`
+	if ini.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, ini.String())
+	}
+	if ini.Get("Other", "listen_port").Int() != 80 || ini.Get("Created", "host").String() != "localhost" || ini.Has("New", "host") {
+		t.Errorf("Expected keys to be found in their new sections")
+	}
+	ini.Set("New", "port", Int(8080))
+	if ini.Get("New", "port").Int() != 8080 || ini.Get("Other", "other").Int() != 1 {
+		t.Errorf("Expected sections to be consistent after the changes")
+	}
+}
+
+func TestEnumerate(t *testing.T) {
+	ini, err := LoadBytes([]byte(`[Server]
+Host=localhost
+port=80
+HOST=ignored
+
+[client]
+retries=3`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ini.Set("Extra", "Enabled", Bool(true, false))
+
+	if sections := strings.Join(ini.Sections(), ","); sections != "Server,client,Extra" {
+		t.Errorf("Expected Server,client,Extra, got %s", sections)
+	}
+	if keys := strings.Join(ini.Keys("server"), ","); keys != "Host,port" {
+		t.Errorf("Expected Host,port, got %s", keys)
+	}
+	if keys := ini.Keys("Missing"); len(keys) != 0 {
+		t.Errorf("Expected no keys, got %v", keys)
+	}
+
+	visited := []string{}
+	ini.Range(func(section string, key string, value TValue) bool {
+		visited = append(visited, section+"."+key+"="+value.String())
+		return key != "retries"
+	})
+	if strings.Join(visited, " ") != "Server.Host=localhost Server.port=80 client.retries=3" {
+		t.Errorf("Unexpected iteration: %v", visited)
+	}
+}
+
+func TestGlobalSection(t *testing.T) {
+	ini, err := LoadBytes([]byte(`; top level keys
+root=www
+charset=utf-8
+
+[Server]
+port=80`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ini.Get("", "root").String() != "www" || !ini.Has("", "charset") || ini.Has("", "port") {
+		t.Errorf("Expected global keys to be found")
+	}
+	if sections := strings.Join(ini.Sections(), ","); sections != ",Server" {
+		t.Errorf("Expected ,Server got %s", sections)
+	}
+	if keys := strings.Join(ini.Keys(""), ","); keys != "root,charset" {
+		t.Errorf("Expected root,charset got %s", keys)
+	}
+
+	ini.Set("", "indent", Int(4))
+	ini.Set("", "root", String("srv"))
+	ini.Set("Server", "host", String("localhost"))
+	expected := `; top level keys
+root=srv
+charset=utf-8
+indent=4
+
+[Server]
+port=80
+host=localhost`
+	if ini.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, ini.String())
+	}
+
+	if !ini.DeleteSection("", false) || ini.Has("", "root") || ini.Get("Server", "host").String() != "localhost" {
+		t.Errorf("Expected global keys to be deleted")
+	}
+
+	ini, err = LoadBytes([]byte(`# file header
+
+# about Server
+[Server]
+port=80`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sections := strings.Join(ini.Sections(), ","); sections != "Server" {
+		t.Errorf("Expected Server got %s", sections)
+	}
+	ini.Set("", "root", String("srv"))
+	ini.Set("Server", "host", String("localhost"))
+	expected = `# file header
+root=srv
+
+# about Server
+[Server]
+port=80
+host=localhost`
+	if ini.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, ini.String())
+	}
+
+	var cfg struct {
+		Root string `ini:"root"`
+	}
+	if err := ini.MapTo(&cfg); err != nil || cfg.Root != "srv" {
+		t.Errorf("Expected srv, got %s, %v", cfg.Root, err)
+	}
+}
+
+func TestSpaces(t *testing.T) {
+	ini, err := LoadBytes([]byte(`[My Section]
+display name = Foo Bar
+key = value	; comment
+empty =
+# commented = key
+arr[0]=1
+quoted = "a ; b" # comment
+
+[ Spaced Out ]
+x = 1`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct{ section, key, value string }{
+		{"My Section", "display name", "Foo Bar"},
+		{"my section", "KEY", "value"},
+		{"My Section", "empty", ""},
+		{"My Section", "arr[0]", "1"},
+		{"My Section", "quoted", "a ; b"},
+		{"Spaced Out", "x", "1"},
+	} {
+		if v, ok := ini.Lookup(c.section, c.key); !ok || v.String() != c.value {
+			t.Errorf("Expected [%s] %s=%s, got %s, %t", c.section, c.key, c.value, v.String(), ok)
+		}
+	}
+	if ini.Has("My Section", "# commented") || ini.Has("My Section", "commented") {
+		t.Error("Expected the commented key to be ignored")
+	}
+	if sections := strings.Join(ini.Sections(), ","); sections != "My Section,Spaced Out" {
+		t.Errorf("Expected My Section,Spaced Out, got %s", sections)
+	}
+
+	ini.Set("My Section", "key", String("other"))
+	ini.Set("My Section", "empty", String("full"))
+	ini.Set("My Section", "display name", String("Baz"))
+	ini.RenameKey("My Section", "display name", "name")
+	ini.Set("My Section", "name", String("Qux"))
+	expected := `[My Section]
+name = Qux
+key = other	; comment
+empty =full
+# commented = key
+arr[0]=1
+quoted = "a ; b" # comment
+
+[ Spaced Out ]
+x = 1`
+	if ini.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, ini.String())
+	}
+}
+
+func TestSyntaxOptions(t *testing.T) {
+	ini, err := LoadBytes([]byte(`! java style comment
+[Database]
+host: db.example.com
+port = 5432
+user admin
+url=http://example.com/path // not a comment
+quote=it's fine ; not a comment
+list=a|b|c`), &TOptions{
+		Delimiters:       []string{":", "=", " "},
+		CommentPrefixes:  []string{"!"},
+		NoInlineComments: true,
+		ArraySeparator:   "|",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct{ key, value string }{
+		{"host", "db.example.com"},
+		{"port", "5432"},
+		{"user", "admin"},
+		{"url", "http://example.com/path // not a comment"},
+		{"quote", "it's fine ; not a comment"},
+	} {
+		if v := ini.Get("Database", c.key).String(); v != c.value {
+			t.Errorf("Expected %s=%s, got %s", c.key, c.value, v)
+		}
+	}
+	if list := ini.Get("Database", "list").StringArray(); len(list) != 3 || list[2] != "c" {
+		t.Errorf("Expected [a b c], got %v", list)
+	}
+	if ini.Has("", "!") || len(ini.Sections()) != 1 {
+		t.Errorf("Expected the comment to be ignored, got %v", ini.Sections())
+	}
+
+	ini.Set("Database", "list", StringArray([]string{"x", "y"}))
+	ini.Set("Database", "pass", String("se;cret # x"))
+	ini.set("Other", "comment", String("new key"), "new key", false)
+	expected := `! java style comment
+[Database]
+host: db.example.com
+port = 5432
+user admin
+url=http://example.com/path // not a comment
+quote=it's fine ; not a comment
+list=x|y
+pass:se;cret # x
+
+[Other]
+! new key
+comment:new key`
+	if ini.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, ini.String())
+	}
+
+	ini, err = LoadBytes([]byte(`[Test]
+url=http://example.com/ # comment
+text=a # b`), &TOptions{CommentPrefixes: []string{"#"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ini.Get("Test", "url").String() != "http://example.com/" || ini.Get("Test", "text").String() != "a" {
+		t.Errorf("Expected comments to be removed, got %s and %s", ini.Get("Test", "url").String(), ini.Get("Test", "text").String())
+	}
+	ini.Set("Test", "url", String("http://other.com/"))
+	ini.Set("Test", "text", String("a # b"))
+	if ini.String() != "[Test]\nurl=http://other.com/ # comment\ntext=\"a # b\" # b" {
+		t.Errorf("Unexpected contents: %q", ini.String())
+	}
+}
+
+func TestMultiLine(t *testing.T) {
+	ini, err := LoadBytes([]byte("[Test]\r\n"+
+		"sql=SELECT * \\\r\n"+
+		"  FROM users \\\r\n"+
+		"  WHERE id = 1\r\n"+
+		"path=C:\\dir\\ ; not continued\r\n"+
+		"next=1\r\n"), &TOptions{MultiLine: MultiLineBackslash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql := ini.Get("Test", "sql").String(); sql != "SELECT *\nFROM users\nWHERE id = 1" {
+		t.Errorf("Expected a multi-line value, got %q", sql)
+	}
+	if ini.Get("Test", "path").String() != "C:\\dir\\" || ini.Get("Test", "next").Int() != 1 {
+		t.Errorf("Expected path and next, got %s and %d", ini.Get("Test", "path").String(), ini.Get("Test", "next").Int())
+	}
+	ini.Set("Test", "next", String("a\nb"))
+	expected := "[Test]\r\n" +
+		"sql=SELECT * \\\r\n" +
+		"  FROM users \\\r\n" +
+		"  WHERE id = 1\r\n" +
+		"path=C:\\dir\\ ; not continued\r\n" +
+		"next=a\\\r\nb\r\n"
+	if ini.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, ini.String())
+	}
+
+	// a \ at the end of a line is quoted, it would continue the value
+	ini, _ = LoadBytes([]byte("[a]\n"), &TOptions{MultiLine: MultiLineBackslash})
+	ini.Set("a", "dir", String(`C:\temp\`))
+	ini.Set("a", "lines", String("a\\\nb\\"))
+	ini.Set("a", "next", Int(1))
+	ini2, err := LoadBytes(ini.Bytes(), &TOptions{MultiLine: MultiLineBackslash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ini2.Get("a", "dir").String() != `C:\temp\` || ini2.Get("a", "lines").String() != "a\\\nb\\" || ini2.Get("a", "next").Int() != 1 {
+		t.Errorf("Expected the backslashes to round trip, got %q", ini.String())
+	}
+
+	// the spaces around the lines are trimmed, a value with them is quoted
+	sql := "SELECT *\n  FROM t \n    WHERE x = 1"
+	for _, mode := range []TMultiLine{MultiLineBackslash, MultiLineIndented} {
+		ini, _ = LoadBytes([]byte("[a]\n"), &TOptions{MultiLine: mode})
+		ini.Set("a", "sql", String(sql))
+		ini2, err = LoadBytes(ini.Bytes(), &TOptions{MultiLine: mode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ini2.Get("a", "sql").String() != sql {
+			t.Errorf("Mode %d: expected the indented value to round trip, got %q", mode, ini.String())
+		}
+	}
+
+	ini, err = LoadBytes([]byte(`[Test]
+cert = -----BEGIN-----
+    MIIB
+
+    -----END-----
+other = 1
+
+after=2`), &TOptions{MultiLine: MultiLineIndented})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert := ini.Get("Test", "cert").String(); cert != "-----BEGIN-----\nMIIB\n\n-----END-----" {
+		t.Errorf("Expected a multi-line value, got %q", cert)
+	}
+	if ini.Get("Test", "other").Int() != 1 || ini.Get("Test", "after").Int() != 2 {
+		t.Errorf("Expected other and after to be keys")
+	}
+	ini.Set("Test", "cert", String("new\nvalue"))
+	ini.Set("Test", "added", String("x\ny"))
+	ini2, err = LoadBytes(ini.Bytes(), &TOptions{MultiLine: MultiLineIndented})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ini2.Get("Test", "cert").String() != "new\nvalue" || ini2.Get("Test", "added").String() != "x\ny" || ini2.Get("Test", "after").Int() != 2 {
+		t.Errorf("Expected multi-line values to round trip, got %q", ini.String())
+	}
+
+	ini, err = LoadBytes([]byte("[a]\n  k=1\n"), &TOptions{MultiLine: MultiLineIndented})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ini.Set("a", "k", String("x\ny"))
+	if expected := "[a]\n  k=x\n  \ty\n"; ini.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, ini.String())
+	}
+	ini2, err = LoadBytes(ini.Bytes(), &TOptions{MultiLine: MultiLineIndented})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ini2.Get("a", "k").String() != "x\ny" || len(ini2.Warnings()) != 0 {
+		t.Errorf("Expected an indented key to round trip, got %q and %v", ini2.Get("a", "k").String(), ini2.Warnings())
+	}
+}
+
+func TestQuoting(t *testing.T) {
+	ini, err := LoadBytes([]byte(`[Test]
+double="say \"hi\" # not a comment" ; comment
+single= 'it \"is\"' ' comment
+escapes="tab\there\nnew line \u00e9 \\ \q"
+unquoted=C:\dir\n
+quoted_number="42"
+dangling="open # comment`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct{ key, value string }{
+		{"double", `say "hi" # not a comment`},
+		{"single", `it "is"`},
+		{"escapes", "tab\there\nnew line é \\ \\q"},
+		{"unquoted", `C:\dir\n`},
+		{"dangling", `"open`},
+	} {
+		if v := ini.Get("Test", c.key).String(); v != c.value {
+			t.Errorf("Expected %s=%q, got %q", c.key, c.value, v)
+		}
+	}
+	if ini.Get("Test", "quoted_number").Int() != 42 {
+		t.Errorf("Expected 42, got %d", ini.Get("Test", "quoted_number").Int())
+	}
+
+	values := []string{
+		`both "quotes" and # hash`,
+		`'single' ; semicolon`,
+		"  spaces  ",
+		"multi\nline\ttab\x01",
+		`back\slash`,
+		"",
+	}
+	for i, v := range values {
+		ini.Set("Test", fmt.Sprintf("value%d", i), TValue{Value: []byte(v)})
+	}
+	ini2, err := LoadBytes(ini.Bytes(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range values {
+		if got := ini2.Get("Test", fmt.Sprintf("value%d", i)).String(); got != v {
+			t.Errorf("Expected %q to round trip, got %q in %s", v, got, ini.String())
+		}
+	}
+	if !strings.Contains(ini.String(), `value0="both \"quotes\" and # hash"`) || !strings.Contains(ini.String(), `value4=back\slash`) {
+		t.Errorf("Unexpected quoting in %s", ini.String())
+	}
+
+	raw, err := LoadBytes([]byte(`[Test]
+path="C:\new\dir" # comment`), &TOptions{RawValues: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.Get("Test", "path").String() != `C:\new\dir` {
+		t.Errorf("Expected raw value, got %s", raw.Get("Test", "path").String())
+	}
+	raw.Set("Test", "path", String(`D:\new\dir # x`))
+	if raw.String() != "[Test]\npath=\"D:\\new\\dir # x\" # comment" {
+		t.Errorf("Unexpected raw contents %q", raw.String())
+	}
+	raw.Set("Test", "double", String(`a "b" # c`))
+	raw.Set("Test", "single", String(`it's # c`))
+	if !strings.Contains(raw.String(), `double='a "b" # c'`) || !strings.Contains(raw.String(), `single="it's # c"`) {
+		t.Errorf("Expected the other quote around raw values, got %q", raw.String())
+	}
+	raw2, err := LoadBytes(raw.Bytes(), &TOptions{RawValues: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw2.Get("Test", "double").String() != `a "b" # c` || raw2.Get("Test", "single").String() != `it's # c` {
+		t.Errorf("Expected raw values to round trip, got %q and %q", raw2.Get("Test", "double").String(), raw2.Get("Test", "single").String())
+	}
+
+	latin1, _ := LoadBytes([]byte("[Test]\n"), nil)
+	latin1.Set("Test", "name", String("caf\xe9 # x"))
+	if !strings.Contains(latin1.String(), "name=\"caf\xe9 # x\"") {
+		t.Errorf("Expected the Latin-1 byte to be kept, got %q", latin1.String())
+	}
+	if latin2, _ := LoadBytes(latin1.Bytes(), nil); latin2.Get("Test", "name").String() != "caf\xe9 # x" {
+		t.Errorf("Expected the Latin-1 value to round trip, got %q", latin2.Get("Test", "name").String())
+	}
+
+	value := []byte("a\nb # c")
+	if string(ValueToSave(value, false)) != `"a\nb # c"` || string(value) != "a\nb # c" {
+		t.Errorf("Expected ValueToSave to quote without changing the value, got %s", ValueToSave(value, false))
+	}
+	if string(ValueToRead([]byte(`"a\"b"`))) != `a"b` {
+		t.Errorf("Expected a\"b, got %s", ValueToRead([]byte(`"a\"b"`)))
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	data := []byte(`; comment
+[Unclosed
+[Good] ; comment
+key without delimiter
+
+  =no key
+quoted="never closed
+[]
+[Other] junk
+ok=1`)
+
+	ini, err := LoadBytes(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ParseError{
+		{Line: 2, Column: 1, Snippet: "[Unclosed", Reason: "unclosed section header"},
+		{Line: 4, Column: 1, Snippet: "key without delimiter", Reason: "missing delimiter"},
+		{Line: 6, Column: 3, Snippet: "  =no key", Reason: "empty key"},
+		{Line: 7, Column: 8, Snippet: `quoted="never closed`, Reason: "unclosed quote"},
+		{Line: 8, Column: 1, Snippet: "[]", Reason: "empty section name"},
+		{Line: 9, Column: 9, Snippet: "[Other] junk", Reason: "unexpected text after section header"},
+	}
+	warnings := ini.Warnings()
+	if len(warnings) != len(expected) {
+		t.Fatalf("Expected %d warnings, got %v", len(expected), warnings)
+	}
+	for i := range expected {
+		if *warnings[i] != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], *warnings[i])
+		}
+	}
+	if ini.Get("Other", "ok").Int() != 1 {
+		t.Errorf("Expected the file to be loaded, got %s", ini.Get("Other", "ok").String())
+	}
+
+	// line numbers count the removed empty lines
+	ini, err = LoadBytes(data, &TOptions{DontPreserveEmptyLines: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := ini.Warnings(); len(w) != len(expected) || w[2].Line != 6 {
+		t.Errorf("Expected line 6, got %v", w)
+	}
+
+	_, err = LoadBytes(data, &TOptions{Strict: true})
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Line != 2 || perr.Reason != "unclosed section header" {
+		t.Fatalf("Expected a ParseError on line 2, got %v", err)
+	}
+	if err.Error() != `goini: 2:1: unclosed section header: "[Unclosed"` {
+		t.Errorf("Unexpected message %s", err.Error())
+	}
+
+	_, err = Load("./test.ini", &TOptions{Strict: true})
+	if err != nil {
+		t.Errorf("Expected test.ini to be valid, got %v", err)
+	}
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "bad.ini"), []byte("[A]\nkey=1\nbroken\n"), 0644)
+	_, err = Load(filepath.Join(dir, "bad.ini"), &TOptions{Strict: true})
+	if !errors.As(err, &perr) || perr.File != filepath.Join(dir, "bad.ini") || perr.Line != 3 {
+		t.Errorf("Expected a ParseError on bad.ini:3, got %v", err)
+	}
+
+	ini, err = LoadBytes([]byte("[A]\nvalue = \"one\\\n  two\"\nbad\n"), &TOptions{MultiLine: MultiLineBackslash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := ini.Warnings(); len(w) != 1 || w[0].Line != 4 {
+		t.Errorf("Expected a warning on line 4 after a multi-line value, got %v", w)
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	data := []byte(`[remote]
+fetch=+refs/heads/*
+url=first
+[other]
+key=1
+[remote]
+fetch=+refs/tags/*
+url=last
+push=yes`)
+
+	for _, c := range []struct {
+		policy TDuplicatePolicy
+		url    string
+		fetch  []string
+	}{
+		{DuplicateFirst, "first", []string{"+refs/heads/*"}},
+		{DuplicateLast, "last", []string{"+refs/tags/*"}},
+		{DuplicateList, "first,last", []string{"+refs/heads/*", "+refs/tags/*"}},
+	} {
+		ini, err := LoadBytes(data, &TOptions{DuplicatePolicy: c.policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ini.Get("remote", "url").String() != c.url {
+			t.Errorf("Policy %d: expected url=%s, got %s", c.policy, c.url, ini.Get("remote", "url").String())
+		}
+		if fetch := ini.Get("remote", "fetch").StringArray(); strings.Join(fetch, " ") != strings.Join(c.fetch, " ") {
+			t.Errorf("Policy %d: expected fetch=%v, got %v", c.policy, c.fetch, fetch)
+		}
+		if ini.Get("remote", "push").String() != "yes" || ini.Get("other", "key").Int() != 1 {
+			t.Errorf("Policy %d: expected the sections to be merged", c.policy)
+		}
+		all := ini.GetAll("Remote", "FETCH")
+		if len(all) != 2 || all[0].String() != "+refs/heads/*" || all[1].String() != "+refs/tags/*" {
+			t.Errorf("Policy %d: expected every fetch, got %v", c.policy, all)
+		}
+		if keys := ini.Keys("remote"); strings.Join(keys, ",") != "fetch,url,push" {
+			t.Errorf("Policy %d: unexpected keys %v", c.policy, keys)
+		}
+	}
+	if all := New(nil).GetAll("remote", "fetch"); len(all) != 0 {
+		t.Errorf("Expected no values, got %v", all)
+	}
+
+	// Set changes the occurrence returned by Get, new keys go after the last one
+	ini, _ := LoadBytes(data, &TOptions{DuplicatePolicy: DuplicateLast})
+	ini.Set("remote", "url", String("changed"))
+	ini.Set("remote", "mirror", String("true"))
+	ini.Set("other", "key", Int(2))
+	expected := "[remote]\nfetch=+refs/heads/*\nurl=first\n[other]\nkey=2\n[remote]\nfetch=+refs/tags/*\nurl=changed\npush=yes\nmirror=true"
+	if ini.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, ini.String())
+	}
+	if ini.Get("other", "key").Int() != 2 || ini.Get("remote", "mirror").String() != "true" {
+		t.Errorf("Unexpected values after Set: %s", ini.String())
+	}
+
+	// with DuplicateList Set and DeleteKey change every occurrence
+	ini, _ = LoadBytes([]byte("[s]\nk=1\nk=2\nk=3\nother=x"), &TOptions{DuplicatePolicy: DuplicateList})
+	ini.Set("s", "k", StringArray([]string{"a", "b"}))
+	if values := ini.Get("s", "k").StringArray(); strings.Join(values, " ") != "a b" || len(ini.GetAll("s", "k")) != 2 ||
+		ini.String() != "[s]\nk=a\nk=b\nother=x" {
+		t.Errorf("Expected [a b], got %v in %q", values, ini.String())
+	}
+	ini.Set("s", "k", StringArray([]string{"a", "b,c", "d"}))
+	if values := ini.Get("s", "k").StringArray(); strings.Join(values, " ") != "a b,c d" ||
+		ini.String() != "[s]\nk=a\nk=b,c\nother=x\nk=d" {
+		t.Errorf("Expected [a b,c d], got %v in %q", values, ini.String())
+	}
+	ini.Set("s", "k", String("e,f"))
+	if values := ini.Get("s", "k").StringArray(); len(values) != 1 || values[0] != "e,f" || ini.String() != "[s]\nk=e,f\nother=x" {
+		t.Errorf("Expected a single value, got %v in %q", values, ini.String())
+	}
+	ini, _ = LoadBytes([]byte("[s]\n; one\nk=1\n; two\nk=2\nother=x"), &TOptions{DuplicatePolicy: DuplicateList})
+	if !ini.DeleteKey("s", "k", true) || ini.Has("s", "k") || ini.String() != "[s]\nother=x" {
+		t.Errorf("Expected every k to be deleted, got %q", ini.String())
+	}
+
+	var perr *ParseError
+	ini, err := LoadBytes([]byte("[a]\nx=1\n[b]\n[a]\ny=2"), &TOptions{DuplicatePolicy: DuplicateMergeSections})
+	if err != nil || ini.Get("a", "x").Int() != 1 || ini.Get("a", "y").Int() != 2 {
+		t.Errorf("Expected the sections to be merged, got %v", err)
+	}
+	if _, err := LoadBytes(data, &TOptions{DuplicatePolicy: DuplicateMergeSections}); !errors.As(err, &perr) ||
+		perr.Reason != "duplicate key" || perr.Line != 7 {
+		t.Errorf("Expected a duplicate key on line 7, got %v", err)
+	}
+	if _, err := LoadBytes(data, &TOptions{DuplicatePolicy: DuplicateError}); !errors.As(err, &perr) ||
+		perr.Reason != "duplicate section" || perr.Line != 6 {
+		t.Errorf("Expected a duplicate section on line 6, got %v", err)
+	}
+	if _, err := LoadBytes([]byte("[a]\nk=1\n[b]\nk=2\nK=3"), &TOptions{DuplicatePolicy: DuplicateError, CaseSensitive: true}); err != nil {
+		t.Errorf("Expected no duplicates, got %v", err)
+	}
+	if _, err := LoadBytes([]byte("[a]\nk=1\n[b]\nk=2\nK=3"), &TOptions{DuplicatePolicy: DuplicateError}); err == nil {
+		t.Errorf("Expected k and K to be duplicates")
+	}
+}
+
+func TestIndex(t *testing.T) {
+	ini := New(nil)
+	model := map[string]string{}
+	check := func(step string) {
+		for name, value := range model {
+			parts := strings.SplitN(name, "|", 2)
+			if got, ok := ini.Lookup(parts[0], parts[1]); !ok || got.String() != value {
+				t.Fatalf("%s: expected [%s] %s=%s, got %q in\n%s", step, parts[0], parts[1], value, got.String(), ini.String())
+			}
+		}
+		n := 0
+		ini.Range(func(section string, key string, value TValue) bool {
+			n++
+			return true
+		})
+		if n != len(model) {
+			t.Fatalf("%s: expected %d keys, got %d", step, len(model), n)
+		}
+	}
+
+	for i := 0; i < 60; i++ {
+		section := fmt.Sprintf("s%d", i%7)
+		if i%5 == 0 {
+			section = ""
+		}
+		key := fmt.Sprintf("k%d", i)
+		ini.Set(section, key, Int(i))
+		model[section+"|"+key] = strconv.Itoa(i)
+	}
+	check("set")
+
+	ini.DeleteKey("s3", "k10", false)
+	delete(model, "s3|k10")
+	ini.DeleteSection("s4", true)
+	for name := range model {
+		if strings.HasPrefix(name, "s4|") {
+			delete(model, name)
+		}
+	}
+	check("delete")
+
+	ini.RenameKey("s1", "k1", "renamed")
+	model["s1|renamed"] = model["s1|k1"]
+	delete(model, "s1|k1")
+	ini.RenameSection("s2", "moved")
+	for name, value := range model {
+		if strings.HasPrefix(name, "s2|") {
+			model["moved|"+name[3:]] = value
+			delete(model, name)
+		}
+	}
+	ini.MoveKey("s6", "", "k6")
+	model["|k6"] = model["s6|k6"]
+	delete(model, "s6|k6")
+	ini.MoveKey("s5", "new", "k12")
+	model["new|k12"] = model["s5|k12"]
+	delete(model, "s5|k12")
+	check("rename")
+
+	ini, err := LoadBytes(ini.Bytes(), &TOptions{CaseSensitive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("reload")
+	if ini.Has("S1", "RENAMED") {
+		t.Errorf("Expected the index to be case sensitive")
+	}
+	ini.Options(&TOptions{})
+	if !ini.Has("S1", "RENAMED") {
+		t.Errorf("Expected the index to follow the options")
+	}
+
+	ini, _ = LoadBytes([]byte("[Server]\nport=1\n"), nil)
+	ini.Options(&TOptions{CaseSensitive: true})
+	ini.Set("Server", "host", String("h"))
+	if expected := "[Server]\nport=1\nhost=h\n"; ini.String() != expected {
+		t.Errorf("Expected the section to follow the options, got %q", ini.String())
+	}
+}
+
+func benchmarkFile(keys int) *TINIFile {
+	var b strings.Builder
+	for i := 0; i < keys; i++ {
+		if i%100 == 0 {
+			fmt.Fprintf(&b, "[section%d]\n", i/100)
+		}
+		fmt.Fprintf(&b, "key%d=%d\n", i, i)
+	}
+	ini, _ := LoadBytes([]byte(b.String()), nil)
+	return ini
+}
+
+func BenchmarkGet(b *testing.B) {
+	for _, keys := range []int{100, 10000, 100000} {
+		ini := benchmarkFile(keys)
+		section := fmt.Sprintf("section%d", (keys-1)/100)
+		key := fmt.Sprintf("key%d", keys-1)
+		b.Run(strconv.Itoa(keys), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if ini.Get(section, key).Int() != keys-1 {
+					b.Fatal("unexpected value")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSet(b *testing.B) {
+	for _, keys := range []int{100, 10000, 100000} {
+		ini := benchmarkFile(keys)
+		b.Run(strconv.Itoa(keys), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				ini.Set("section0", "key0", Int(i))
+			}
+		})
+	}
+}
+
+func TestScan(t *testing.T) {
+	data := "global=1\r\n; about a\r\n[a]\r\nkey = \"quoted # value\" # comment\rbroken\n\n[b] \nlist=1,2\\\n  3\nlast=x"
+	events := []TEvent{}
+	err := Scan(strings.NewReader(data), &TOptions{MultiLine: MultiLineBackslash}, func(e TEvent) error {
+		events = append(events, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []TEvent{
+		{Type: EventKey, Section: "", Key: "global", Line: 1},
+		{Type: EventComment, Section: "", Comment: "; about a", Line: 2},
+		{Type: EventSection, Section: "a", Line: 3},
+		{Type: EventKey, Section: "a", Key: "key", Line: 4},
+		{Type: EventSection, Section: "b", Line: 7},
+		{Type: EventKey, Section: "b", Key: "list", Line: 8},
+		{Type: EventKey, Section: "b", Key: "last", Line: 10},
+	}
+	values := []string{"1", "", "", "quoted # value", "", "1,2\n3", "x"}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %d events, got %+v", len(expected), events)
+	}
+	for i := range expected {
+		e := events[i]
+		if e.Type != expected[i].Type || e.Section != expected[i].Section || e.Key != expected[i].Key ||
+			e.Comment != expected[i].Comment || e.Line != expected[i].Line || e.Value.String() != values[i] {
+			t.Errorf("Expected %+v with value %q, got %+v with value %q", expected[i], values[i], e, e.Value.String())
+		}
+	}
+	if events[5].Value.StringArray()[1] != "2\n3" {
+		t.Errorf("Expected the value to convert, got %v", events[5].Value.StringArray())
+	}
+
+	// the keys are the same as with Load
+	ini, _ := LoadBytes([]byte(data), &TOptions{MultiLine: MultiLineBackslash})
+	for _, e := range events {
+		if e.Type == EventKey && ini.Get(e.Section, e.Key).String() != e.Value.String() {
+			t.Errorf("Expected [%s] %s=%q, got %q", e.Section, e.Key, ini.Get(e.Section, e.Key).String(), e.Value.String())
+		}
+	}
+
+	indented := "[a]\nkey=one\n\n  two\n\nother=1\n"
+	keys := []string{}
+	Scan(strings.NewReader(indented), &TOptions{MultiLine: MultiLineIndented}, func(e TEvent) error {
+		if e.Type == EventKey {
+			keys = append(keys, fmt.Sprintf("%s=%q@%d", e.Key, e.Value.String(), e.Line))
+		}
+		return nil
+	})
+	if strings.Join(keys, " ") != `key="one\n\ntwo"@2 other="1"@6` {
+		t.Errorf("Unexpected indented keys %v", keys)
+	}
+
+	var perr *ParseError
+	err = Scan(strings.NewReader(data), &TOptions{Strict: true}, func(e TEvent) error { return nil })
+	if !errors.As(err, &perr) || perr.Line != 5 || perr.Reason != "missing delimiter" {
+		t.Errorf("Expected a ParseError on line 5, got %v", err)
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	err = Scan(strings.NewReader(data), nil, func(e TEvent) error {
+		n++
+		if e.Type == EventSection {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || n != 3 {
+		t.Errorf("Expected Scan to stop on the third event, got %v after %d", err, n)
+	}
+}
+
+func TestParseLineAllocations(t *testing.T) {
+	ini := New(nil)
+	for _, line := range []string{
+		"  [Section Name] ; comment",
+		`key name = "quoted value # not a comment" ; comment`,
+		"key=value",
+		"# comment",
+	} {
+		if allocs := testing.AllocsPerRun(100, func() { ini.parseLine(line) }); allocs > 0 {
+			t.Errorf("Expected no allocations parsing %q, got %v", line, allocs)
+		}
+	}
+}
+
+func benchmarkData(keys int) []byte {
+	var b bytes.Buffer
+	for i := 0; i < keys; i++ {
+		if i%100 == 0 {
+			fmt.Fprintf(&b, "; section %d\n[section%d]\n", i/100, i/100)
+		}
+		fmt.Fprintf(&b, "key%d = \"value %d\" ; comment\n", i, i)
+	}
+	return b.Bytes()
+}
+
+func BenchmarkLoad(b *testing.B) {
+	data := benchmarkData(100000)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		LoadBytes(data, nil)
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	data := benchmarkData(100000)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Scan(bytes.NewReader(data), nil, func(e TEvent) error { return nil })
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	ini, err := LoadBytes([]byte("[Server]\nport=8080\nhost=localhost\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "concurrent.ini")
+	done := make(chan bool)
+	for w := 0; w < 8; w++ {
+		go func(w int) {
+			defer func() { done <- true }()
+			for i := 0; i < 200; i++ {
+				switch w % 4 {
+				case 0:
+					ini.Set("Server", "port", Int(8000+i))
+					ini.Set(fmt.Sprintf("Worker%d", w), fmt.Sprintf("key%d", i), Int(i))
+					ini.DeleteKey(fmt.Sprintf("Worker%d", w), fmt.Sprintf("key%d", i-1), false)
+				case 1:
+					if ini.Get("Server", "host").String() != "localhost" {
+						t.Error("Expected host to be localhost")
+						return
+					}
+					ini.GetIntOr("Server", "port", 0)
+					ini.Keys("Server")
+				case 2:
+					ini.Range(func(section string, key string, value TValue) bool {
+						ini.Get(section, key)
+						return true
+					})
+					var cfg struct {
+						Server struct {
+							Host string `ini:"host"`
+						}
+					}
+					ini.MapTo(&cfg)
+				case 3:
+					if err := ini.Save(path); err != nil {
+						t.Error(err)
+						return
+					}
+					ini.Bytes()
+				}
+			}
+		}(w)
+	}
+	for w := 0; w < 8; w++ {
+		<-done
+	}
+	if ini.GetIntOr("Server", "port", 0) != 8199 {
+		t.Errorf("Expected port 8199, got %d", ini.GetIntOr("Server", "port", 0))
+	}
+	if keys := ini.Keys("Worker0"); len(keys) != 1 || keys[0] != "key199" {
+		t.Errorf("Expected only key199, got %v", keys)
+	}
+}
+
+func TestCloneAndSnapshot(t *testing.T) {
+	ini, err := LoadBytes([]byte("; config\n[a]\nkey=1\n[b]\nkey=2\n"), &TOptions{ArraySeparator: "|"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := ini.String()
+
+	clone := ini.Clone()
+	snapshot := ini.Snapshot()
+	clone.Set("a", "key", Int(10))
+	clone.Set("a", "new", String("x"))
+	clone.DeleteSection("b", false)
+	clone.Options(&TOptions{ArraySeparator: ";"})
+	if ini.String() != original || snapshot.String() != original {
+		t.Errorf("Expected the clone to have its own lines, got %q", ini.String())
+	}
+	if ini.Get("a", "key").separator != "|" || clone.Get("a", "key").separator != ";" || ini.Get("b", "key").Int() != 2 {
+		t.Errorf("Expected the clone to have its own options")
+	}
+	if clone.Get("a", "key").Int() != 10 || clone.Get("a", "new").String() != "x" || clone.Has("b", "key") {
+		t.Errorf("Unexpected clone %q", clone.String())
+	}
+
+	ini.Set("a", "key", Int(20))
+	ini.MoveKey("b", "c", "key")
+	ini.RenameSection("a", "renamed")
+	if snapshot.String() != original || snapshot.Get("a", "key").Int() != 1 || snapshot.Get("b", "key").Int() != 2 {
+		t.Errorf("Expected the snapshot to be unchanged, got %q", snapshot.String())
+	}
+	if strings.Join(snapshot.Sections(), ",") != "a,b" || strings.Join(snapshot.Keys("a"), ",") != "key" {
+		t.Errorf("Unexpected snapshot sections %v", snapshot.Sections())
+	}
+	if ini.Get("renamed", "key").Int() != 20 || ini.Get("c", "key").Int() != 2 {
+		t.Errorf("Unexpected file %q", ini.String())
+	}
+
+	edit := snapshot.Clone()
+	edit.Set("a", "key", Int(3))
+	if edit.Get("a", "key").Int() != 3 || snapshot.Get("a", "key").Int() != 1 {
+		t.Errorf("Expected the clone of the snapshot to be independent")
+	}
+
+	// a snapshot is consistent while the file changes
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 500; i++ {
+			ini.Set("pair", "first", Int(i))
+			ini.Set("pair", "second", Int(i))
+		}
+		close(done)
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		s := ini.Snapshot()
+		if s.GetIntOr("pair", "second", 0) > s.GetIntOr("pair", "first", 0) {
+			t.Fatalf("Inconsistent snapshot %q", s.String())
+		}
+	}
+
+	// the clone has its own delimiters and comment prefixes
+	options := &TOptions{Delimiters: []string{"="}, CommentPrefixes: []string{"#"}}
+	ini, _ = LoadBytes([]byte("[a]\nkey=1 ; x\n"), options)
+	clone = ini.Clone()
+	options.Delimiters[0] = ":"
+	options.CommentPrefixes[0] = ";"
+	clone.Set("a", "new", String("2"))
+	if expected := "[a]\nkey=1 ; x\nnew=2\n"; clone.String() != expected {
+		t.Errorf("Expected the clone to keep its options, got %q", clone.String())
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watch.ini")
+	write := func(contents string) {
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("[server]\nport=80\nhost=a\n[old]\nkey=1\n")
+
+	calls := make(chan []TChange, 10)
+	errs := make(chan error, 10)
+	w, err := Watch(path, &TWatchOptions{
+		Options:  &TOptions{Strict: true},
+		Interval: 5 * time.Millisecond,
+		Debounce: 20 * time.Millisecond,
+		OnError:  func(err error) { errs <- err },
+	}, func(old *TINIFile, new *TINIFile, changes []TChange) {
+		if old.Get("server", "port").Int() != 80 || new.Get("server", "port").Int() != 8080 {
+			t.Errorf("Unexpected files %q and %q", old.String(), new.String())
+		}
+		calls <- changes
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if w.File().Get("server", "host").String() != "a" {
+		t.Fatalf("Expected the file to be loaded, got %q", w.File().String())
+	}
+
+	// rapid writes are loaded once
+	for i := 0; i < 5; i++ {
+		write(fmt.Sprintf("[server]\nport=8080\n%s\n[new]\nkey=1\n", strings.Repeat("; writing\n", i)))
+		time.Sleep(2 * time.Millisecond)
+	}
+	var changes []TChange
+	select {
+	case changes = <-calls:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the file to be reloaded")
+	}
+	summary := []string{}
+	for _, c := range changes {
+		summary = append(summary, fmt.Sprintf("%d[%s]%s:%s>%s", c.Type, c.Section, c.Key, c.Old.String(), c.New.String()))
+	}
+	expected := "2[server]port:80>8080 1[server]host:a> 1[old]:> 1[old]key:1> 0[new]:> 0[new]key:>1"
+	if strings.Join(summary, " ") != expected {
+		t.Errorf("Expected changes %s, got %s", expected, strings.Join(summary, " "))
+	}
+	select {
+	case <-calls:
+		t.Errorf("Expected a single reload")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// a broken file keeps the last good one
+	write("[server\nport=1\n")
+	select {
+	case err := <-errs:
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.File != path {
+			t.Errorf("Expected a ParseError, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected an error")
+	}
+	if w.File().Get("server", "port").Int() != 8080 {
+		t.Errorf("Expected the last good file, got %q", w.File().String())
+	}
+
+	w.Close()
+	write("[server]\nport=1\n")
+	time.Sleep(50 * time.Millisecond)
+	if len(calls) > 0 || w.File().Get("server", "port").Int() != 8080 {
+		t.Errorf("Expected no reload after Close")
+	}
+
+	if _, err := Watch(filepath.Join(t.TempDir(), "missing.ini"), nil, nil); err == nil {
+		t.Errorf("Expected an error for a missing file")
+	}
+}
+
+func TestLayers(t *testing.T) {
+	dir := t.TempDir()
+	system := filepath.Join(dir, "system.ini")
+	user := filepath.Join(dir, "user.ini")
+	local := filepath.Join(dir, "local.ini")
+	os.WriteFile(system, []byte("; defaults\n[server]\nport=80\nhost=localhost\n\n[log]\nlevel=info\n"), 0644)
+	os.WriteFile(user, []byte("[Server]\n\n\nport=8080\n"), 0644)
+
+	layers, err := LoadLayers(&TOptions{DontPreserveEmptyLines: true}, system, user, local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layers.Len() != 3 || layers.Layer(3) != nil || layers.Layer(-1) != nil {
+		t.Fatalf("Expected 3 layers, got %d", layers.Len())
+	}
+	if layers.GetIntOr("server", "port", 0) != 8080 || layers.Get("server", "host").String() != "localhost" {
+		t.Errorf("Expected port 8080 on localhost, got %d on %s", layers.GetIntOr("server", "port", 0), layers.Get("server", "host").String())
+	}
+	if layers.GetInt64Or("server", "port", 0) != 8080 || layers.GetUint64Or("server", "port", 0) != 8080 ||
+		layers.GetFloat64Or("server", "port", 0) != 8080 || layers.GetFloat64Or("server", "host", 1.5) != 1.5 {
+		t.Errorf("Expected the typed getters to read the port")
+	}
+	if layers.Layer(0).options == layers.Layer(1).options {
+		t.Errorf("Expected every layer to have its own options")
+	}
+	if layers.Has("server", "missing") || layers.GetStringOr("log", "file", "stderr") != "stderr" {
+		t.Errorf("Expected missing keys to be missing")
+	}
+	if strings.Join(layers.Sections(), ",") != "server,log" || strings.Join(layers.Keys("server"), ",") != "port,host" {
+		t.Errorf("Unexpected sections %v and keys %v", layers.Sections(), layers.Keys("server"))
+	}
+
+	for _, c := range []struct {
+		section, key string
+		origin       TOrigin
+	}{
+		{"server", "port", TOrigin{Layer: 1, File: user, Line: 4}},
+		{"server", "host", TOrigin{Layer: 0, File: system, Line: 4}},
+		{"log", "level", TOrigin{Layer: 0, File: system, Line: 7}},
+	} {
+		if origin, ok := layers.Origin(c.section, c.key); !ok || origin != c.origin {
+			t.Errorf("Expected %s.%s from %+v, got %+v", c.section, c.key, c.origin, origin)
+		}
+	}
+	if _, ok := layers.Origin("server", "missing"); ok {
+		t.Errorf("Expected no origin for a missing key")
+	}
+
+	layers.Set(2, "log", "level", String("debug"))
+	layers.Set(5, "log", "level", String("ignored"))
+	if layers.Get("log", "level").String() != "debug" {
+		t.Errorf("Expected the local layer to win, got %s", layers.Get("log", "level").String())
+	}
+	if origin, _ := layers.Origin("log", "level"); origin != (TOrigin{Layer: 2, File: local}) {
+		t.Errorf("Expected a key without line, got %+v", origin)
+	}
+	if err := layers.Save(2); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(local); strings.TrimSpace(string(data)) != "[log]\nlevel=debug" {
+		t.Errorf("Expected only the local layer to be saved, got %q", data)
+	}
+	if data, _ := os.ReadFile(system); !strings.Contains(string(data), "level=info") {
+		t.Errorf("Expected the system layer to be unchanged, got %q", data)
+	}
+	if layers.Save(3) == nil || NewLayers(New(nil)).Save(0) == nil {
+		t.Errorf("Expected errors saving a missing layer or a layer without file")
+	}
+
+	top, _ := LoadBytes([]byte("[server]\nport=9090\n"), nil)
+	layers.Add(top)
+	if origin, _ := layers.Origin("server", "port"); layers.GetIntOr("server", "port", 0) != 9090 || origin != (TOrigin{Layer: 3, Line: 2}) {
+		t.Errorf("Expected the added layer to win, got %+v", origin)
+	}
+
+	// the names are normalized under the lock of the layer (go test -race)
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			top.Options(&TOptions{CaseSensitive: i%2 == 0})
+		}
+		close(done)
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		layers.Sections()
+		layers.Keys("server")
+	}
+}
+
+func TestEnvOverrides(t *testing.T) {
+	env := map[string]string{
+		"APP_SERVER_PORT":       "9090",
+		"APP_DEBUG":             "true",
+		"APP_SERVER_TLS_CERT":   "/run/secrets/cert",
+		"APP_SERVER_NEW_KEY":    "not in the file",
+		"APP__SERVER__HOST":     "example.com",
+		"OTHER_SERVER_PORT":     "1",
+		"APP_SERVER_HOST_EMPTY": "",
+	}
+	for name, value := range env {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+	data := []byte("debug=false\n[Server]\nport=8080\nhost=localhost\nhost empty=x\n[Server.TLS]\ncert=./cert.pem\n")
+
+	ini, err := LoadBytes(data, &TOptions{EnvPrefix: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ini.Get("server", "port").Int() != 9090 || !ini.Get("", "debug").Bool() || ini.Get("Server.TLS", "cert").String() != "/run/secrets/cert" {
+		t.Errorf("Expected the environment to win, got %d %v %s", ini.Get("server", "port").Int(), ini.Get("", "debug").Bool(), ini.Get("Server.TLS", "cert").String())
+	}
+	if ini.Get("server", "host").String() != "localhost" || ini.Get("server", "new key").String() != "not in the file" {
+		t.Errorf("Unexpected values %s and %s", ini.Get("server", "host").String(), ini.Get("server", "new key").String())
+	}
+	if v, ok := ini.Lookup("server", "host empty"); !ok || v.String() != "" {
+		t.Errorf("Expected an empty variable to override, got %q", v.String())
+	}
+	if all := ini.GetAll("server", "port"); len(all) != 1 || all[0].Int() != 9090 {
+		t.Errorf("Expected GetAll to use the environment, got %v", all)
+	}
+	layers := NewLayers(ini, New(nil))
+	if origin, ok := layers.Origin("server", "port"); !ok || origin != (TOrigin{Layer: 0, Env: "APP_SERVER_PORT"}) {
+		t.Errorf("Expected the port from the environment, got %+v", origin)
+	}
+	if origin, ok := layers.Origin("server", "host"); !ok || origin != (TOrigin{Layer: 0, Line: 4}) {
+		t.Errorf("Expected the host from the file, got %+v", origin)
+	}
+	ini.Set("server", "port", Int(1))
+	if ini.GetIntOr("server", "port", 0) != 9090 || !strings.Contains(ini.String(), "port=1") {
+		t.Errorf("Expected Set to change the file only")
+	}
+	var cfg struct {
+		Server struct {
+			Port int `ini:"port"`
+		}
+	}
+	if err := ini.MapTo(&cfg); err != nil || cfg.Server.Port != 9090 {
+		t.Errorf("Expected MapTo to use the environment, got %d %v", cfg.Server.Port, err)
+	}
+
+	overrides := ini.EnvOverrides()
+	summary := []string{}
+	for _, o := range overrides {
+		summary = append(summary, fmt.Sprintf("%s=%s([%s] %s=%s)", o.Name, o.Value, o.Section, o.Key, o.FileValue))
+	}
+	expected := "APP_DEBUG=true([] debug=false) APP_SERVER_PORT=9090([Server] port=1) APP_SERVER_HOST_EMPTY=([Server] host empty=x) APP_SERVER_TLS_CERT=/run/secrets/cert([Server.TLS] cert=./cert.pem)"
+	if strings.Join(summary, " ") != expected {
+		t.Errorf("Expected %s, got %s", expected, strings.Join(summary, " "))
+	}
+
+	ini, _ = LoadBytes(data, &TOptions{EnvPrefix: "APP", EnvSeparator: "__"})
+	if ini.Get("server", "host").String() != "example.com" || ini.Get("server", "port").Int() != 8080 {
+		t.Errorf("Expected the separator to be used, got %s", ini.Get("server", "host").String())
+	}
+	os.Setenv("APP.SERVER.PORT", "7070")
+	defer os.Unsetenv("APP.SERVER.PORT")
+	ini, _ = LoadBytes(data, &TOptions{EnvPrefix: "APP", EnvSeparator: "."})
+	if ini.Get("server", "port").Int() != 7070 || ini.Get("server", "host").String() != "localhost" {
+		t.Errorf("Expected APP.SERVER.PORT, got %d", ini.Get("server", "port").Int())
+	}
+	ini, _ = LoadBytes(data, nil)
+	if ini.Get("server", "port").Int() != 8080 || len(ini.EnvOverrides()) != 0 {
+		t.Errorf("Expected no overrides without EnvPrefix")
+	}
+}
+
+func TestRenameValidation(t *testing.T) {
+	ini, err := LoadBytes([]byte("[a]\nk=1\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"", "x=y", " x", "x ", "[x", "x;y", "x # y", "x]y", "x\ny"} {
+		if ini.RenameKey("a", "k", name) {
+			t.Errorf("Expected the key name %q to be rejected", name)
+		}
+		if ini.RenameSection("a", name) {
+			t.Errorf("Expected the section name %q to be rejected", name)
+		}
+	}
+	if !ini.RenameKey("a", "k", "new key") || !ini.RenameSection("a", "b.c") {
+		t.Errorf("Expected valid names to work")
+	}
+	ini, _ = LoadBytes(ini.Bytes(), nil)
+	if ini.Get("b.c", "new key").Int() != 1 {
+		t.Errorf("Expected the renamed key to be read back, got %q", ini.String())
+	}
+
+	spaced, _ := LoadBytes([]byte("[a]\nk 1\n"), &TOptions{Delimiters: []string{" "}})
+	if spaced.RenameKey("a", "k", "two words") || !spaced.RenameKey("a", "k", "other") {
+		t.Errorf("Expected a space to be rejected when it is a delimiter")
+	}
+}
@@ -0,0 +1,86 @@
+package goini
+
+import "sort"
+
+// The index maps the normalized names of the sections to their position in
+// sections, and the normalized names of the keys to the lines where they are,
+// so Get does not depend on the size of the file.
+
+// indexSections rebuilds the index of the sections.
+func (t *TINIFile) indexSections() {
+	t.sectionIndex = make(map[string]int, len(t.sections))
+	for i := range t.sections {
+		t.sectionIndex[t.sections[i].Section] = i
+	}
+}
+
+// addSection registers a new section at the end of sections.
+func (t *TINIFile) addSection(sec _TSection) {
+	t.sectionIndex[sec.Section] = len(t.sections)
+	t.sections = append(t.sections, sec)
+}
+
+// indexKeys rebuilds the index of the keys.
+func (t *TINIFile) indexKeys() {
+	t.keyIndex = map[string]map[string][]int{}
+	for i := range t.lines {
+		t.indexLine(i)
+	}
+}
+
+// indexLine adds the line to the index of the keys if it is a key.
+func (t *TINIFile) indexLine(i int) {
+	if t.lines[i].Mode != KEY {
+		return
+	}
+	sectionKey := t.sectionKey(t.lines[i].Section)
+	keys := t.keyIndex[sectionKey]
+	if keys == nil {
+		keys = map[string][]int{}
+		t.keyIndex[sectionKey] = keys
+	}
+	key := t.sectionKey(t.lines[i].Key)
+	keys[key] = append(keys[key], i)
+	if n := len(keys[key]); n > 1 && keys[key][n-2] > i {
+		sort.Ints(keys[key])
+	}
+}
+
+// unindexLine removes the line from the index of the keys.
+func (t *TINIFile) unindexLine(i int) {
+	if t.lines[i].Mode != KEY {
+		return
+	}
+	keys := t.keyIndex[t.sectionKey(t.lines[i].Section)]
+	key := t.sectionKey(t.lines[i].Key)
+	for n := range keys[key] {
+		if keys[key][n] == i {
+			keys[key] = append(keys[key][:n], keys[key][n+1:]...)
+			break
+		}
+	}
+	if len(keys[key]) == 0 {
+		delete(keys, key)
+	}
+}
+
+// shiftKeys moves the lines of the index from the line at by n.
+func (t *TINIFile) shiftKeys(at int, n int) {
+	if at >= len(t.lines) {
+		return
+	}
+	for _, keys := range t.keyIndex {
+		for _, lines := range keys {
+			for i := range lines {
+				if lines[i] >= at {
+					lines[i] += n
+				}
+			}
+		}
+	}
+}
+
+// keyLines returns the lines of every occurrence of the key.
+func (t *TINIFile) keyLines(section string, key string) []int {
+	return t.keyIndex[t.sectionKey(section)][t.sectionKey(key)]
+}
@@ -0,0 +1,250 @@
+package goini
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLayers stacks files where the keys of a layer hide the keys of the layers
+// below it, like a system, a user and a local file.
+type TLayers struct {
+	mu     sync.RWMutex
+	layers []*TINIFile // the lowest priority first
+}
+
+// TOrigin is where the effective value of a key comes from.
+type TOrigin struct {
+	Layer int    // position of the layer, 0 is the lowest priority
+	File  string // Filename of the layer
+	Line  int    // line of the key when the layer was loaded, 0 if it was set later
+	Env   string // environment variable of the value, empty if it comes from the file
+}
+
+// NewLayers returns the files as layers, the lowest priority first.
+func NewLayers(files ...*TINIFile) *TLayers {
+	return &TLayers{layers: append([]*TINIFile{}, files...)}
+}
+
+// LoadLayers loads every path as a layer, the lowest priority first. A file
+// that does not exist is an empty layer that is created on Save. Every layer
+// gets its own copy of the options.
+func LoadLayers(o *TOptions, paths ...string) (*TLayers, error) {
+	l := &TLayers{}
+	for _, path := range paths {
+		options := o
+		if o != nil {
+			options = copyOptions(o)
+		}
+		file, err := Load(path, options)
+		if errors.Is(err, os.ErrNotExist) {
+			file, err = New(options), nil
+			file.Filename = path
+		}
+		if err != nil {
+			return nil, err
+		}
+		l.layers = append(l.layers, file)
+	}
+	return l, nil
+}
+
+// Add puts the file on top of the other layers.
+func (l *TLayers) Add(file *TINIFile) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.layers = append(l.layers, file)
+}
+
+// Layer returns the file of the layer or nil.
+func (l *TLayers) Layer(layer int) *TINIFile {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if layer < 0 || layer >= len(l.layers) {
+		return nil
+	}
+	return l.layers[layer]
+}
+
+// Len returns the number of layers.
+func (l *TLayers) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.layers)
+}
+
+func (l *TLayers) Get(section string, key string) TValue {
+	v, _ := l.Lookup(section, key)
+	return v
+}
+
+// Lookup returns the value of the key in the highest layer that has it.
+func (l *TLayers) Lookup(section string, key string) (TValue, bool) {
+	_, v, ok := l.find(section, key)
+	return v, ok
+}
+
+func (l *TLayers) Has(section string, key string) bool {
+	_, ok := l.Lookup(section, key)
+	return ok
+}
+
+func (l *TLayers) GetStringOr(section string, key string, def string) string {
+	if v, ok := l.Lookup(section, key); ok {
+		return v.String()
+	}
+	return def
+}
+
+func (l *TLayers) GetBoolOr(section string, key string, def bool) bool {
+	if v, ok := l.Lookup(section, key); ok {
+		if b, err := v.BoolE(); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+func (l *TLayers) GetIntOr(section string, key string, def int) int {
+	if v, ok := l.Lookup(section, key); ok {
+		if i, err := v.IntE(); err == nil {
+			return i
+		}
+	}
+	return def
+}
+
+func (l *TLayers) GetInt64Or(section string, key string, def int64) int64 {
+	if v, ok := l.Lookup(section, key); ok {
+		if i, err := v.Int64E(); err == nil {
+			return i
+		}
+	}
+	return def
+}
+
+func (l *TLayers) GetUint64Or(section string, key string, def uint64) uint64 {
+	if v, ok := l.Lookup(section, key); ok {
+		if i, err := v.Uint64E(); err == nil {
+			return i
+		}
+	}
+	return def
+}
+
+func (l *TLayers) GetFloat64Or(section string, key string, def float64) float64 {
+	if v, ok := l.Lookup(section, key); ok {
+		if f, err := v.Float64E(); err == nil {
+			return f
+		}
+	}
+	return def
+}
+
+func (l *TLayers) GetDurationOr(section string, key string, def time.Duration) time.Duration {
+	if v, ok := l.Lookup(section, key); ok {
+		if d, err := v.DurationE(); err == nil {
+			return d
+		}
+	}
+	return def
+}
+
+// Origin returns the layer, the file and the line of the effective value of
+// the key, or the environment variable when the EnvPrefix of the layer
+// overrides it.
+func (l *TLayers) Origin(section string, key string) (TOrigin, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for i := len(l.layers) - 1; i >= 0; i-- {
+		file := l.layers[i]
+		file.mu.RLock()
+		origin := TOrigin{Layer: i, File: file.Filename}
+		_, env := file.lookupEnv(section, key)
+		line := -1
+		if env {
+			origin.Env = file.envName(section, key)
+		} else if line = file.findKey(section, key); line >= 0 {
+			origin.Line = file.lines[line].Number
+		}
+		file.mu.RUnlock()
+		if env || line >= 0 {
+			return origin, true
+		}
+	}
+	return TOrigin{}, false
+}
+
+// find returns the highest layer with the key and its value.
+func (l *TLayers) find(section string, key string) (int, TValue, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for i := len(l.layers) - 1; i >= 0; i-- {
+		if v, ok := l.layers[i].Lookup(section, key); ok {
+			return i, v, true
+		}
+	}
+	return -1, TValue{section: section, key: key}, false
+}
+
+// Sections returns the sections of every layer, in the order they are found
+// from the lowest layer.
+func (l *TLayers) Sections() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	names := []string{}
+	seen := map[string]bool{}
+	for _, file := range l.layers {
+		file.mu.RLock() // the options of the file normalize the names
+		for _, section := range file.sectionNames() {
+			if name := file.sectionKey(section); !seen[name] {
+				seen[name] = true
+				names = append(names, section)
+			}
+		}
+		file.mu.RUnlock()
+	}
+	return names
+}
+
+// Keys returns the keys of the section in every layer, in the order they are
+// found from the lowest layer.
+func (l *TLayers) Keys(section string) []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	keys := []string{}
+	seen := map[string]bool{}
+	for _, file := range l.layers {
+		file.mu.RLock()
+		for _, key := range file.keys(section) {
+			if name := file.sectionKey(key); !seen[name] {
+				seen[name] = true
+				keys = append(keys, key)
+			}
+		}
+		file.mu.RUnlock()
+	}
+	return keys
+}
+
+// Set changes or creates the key in the layer, it does nothing if the layer
+// does not exist.
+func (l *TLayers) Set(layer int, section string, key string, value TValue) {
+	if file := l.Layer(layer); file != nil {
+		file.Set(section, key, value)
+	}
+}
+
+// Save writes the layer to its Filename, the other layers are not saved.
+func (l *TLayers) Save(layer int) error {
+	file := l.Layer(layer)
+	if file == nil {
+		return fmt.Errorf("goini: layer %d does not exist", layer)
+	}
+	if file.Filename == "" {
+		return fmt.Errorf("goini: layer %d has no file name", layer)
+	}
+	return file.Save(file.Filename)
+}
@@ -0,0 +1,340 @@
+package goini
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Struct mapping
+//
+// Fields are mapped to keys by the `ini:"name"` tag or the field name, and
+// `ini:"-"` skips the field. Struct fields are sections named by the
+// `section:"name"` tag, the ini tag or the field name, nested structs inside a
+// section are named "Parent.Child". Fields outside any struct belong to the
+// global section unless they have a section tag. Missing keys take the
+// `default:"..."` tag or keep the current value, `required:"true"` makes a
+// missing key an error. ReflectFrom writes the `comment:"..."` tag above the
+// keys it creates.
+
+var ErrRequired = errors.New("required key is missing")
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// FieldError is a failure mapping a struct field, Err is a *ConversionError
+// or ErrRequired.
+type FieldError struct {
+	Field   string
+	Section string
+	Key     string
+	Err     error
+}
+
+func (e *FieldError) Error() string {
+	if e.Err == ErrRequired {
+		return fmt.Sprintf("goini: field %s: [%s] %s: %v", e.Field, e.Section, e.Key, e.Err)
+	}
+	return fmt.Sprintf("goini: field %s: %v", e.Field, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// MappingError lists every field that failed in a single MapTo call.
+type MappingError struct {
+	Errors []error
+}
+
+func (e *MappingError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i := range e.Errors {
+		msgs[i] = e.Errors[i].Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *MappingError) Unwrap() []error {
+	return e.Errors
+}
+
+// Unmarshal fills the struct pointed by v with the values of ini.
+func Unmarshal(ini *TINIFile, v interface{}) error {
+	return ini.MapTo(v)
+}
+
+// MapTo fills the struct pointed by v with the values of the file.
+func (t *TINIFile) MapTo(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("goini: MapTo needs a non-nil pointer to a struct, got %T", v)
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	errs := []error{}
+	walkStruct(rv.Elem(), "", "", true, func(field reflect.StructField, fv reflect.Value, section string, key string, path string) {
+		value, found := t.lookup(section, key)
+		if !found {
+			if def, ok := field.Tag.Lookup("default"); ok {
+				value, found = TValue{Value: []byte(def), section: section, key: key}, true
+			} else if field.Tag.Get("required") == "true" {
+				errs = append(errs, &FieldError{Field: path, Section: section, Key: key, Err: ErrRequired})
+			}
+		}
+		if found {
+			if err := setField(fv, value); err != nil {
+				errs = append(errs, &FieldError{Field: path, Section: section, Key: key, Err: err})
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return &MappingError{Errors: errs}
+	}
+	return nil
+}
+
+// Marshal returns a new INI file with the values of the struct v.
+func Marshal(v interface{}) (*TINIFile, error) {
+	t := New(nil)
+	if err := t.ReflectFrom(v); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ReflectFrom sets every field of the struct v in the file, empty ones too,
+// new keys get the `comment:"..."` tag as a comment above them.
+func (t *TINIFile) ReflectFrom(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("goini: ReflectFrom needs a struct or a pointer to a struct, got %T", v)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.own()
+	errs := []error{}
+	walkStruct(rv, "", "", false, func(field reflect.StructField, fv reflect.Value, section string, key string, path string) {
+		value, ok, err := fieldValue(fv)
+		if err != nil {
+			errs = append(errs, &FieldError{Field: path, Section: section, Key: key, Err: err})
+		} else if ok {
+			t.set(section, key, value, field.Tag.Get("comment"), true)
+		}
+	})
+	if len(errs) > 0 {
+		return &MappingError{Errors: errs}
+	}
+	return nil
+}
+
+// walkStruct calls fn for every field mapped to a key, alloc creates the nil
+// pointers to sections, otherwise they are skipped.
+func walkStruct(rv reflect.Value, section string, path string, alloc bool, fn func(field reflect.StructField, fv reflect.Value, section string, key string, path string)) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		fv := rv.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue // unexported
+		}
+		name, ok := fieldName(field)
+		if !ok {
+			continue
+		}
+
+		if isSection(field.Type) {
+			if field.Type.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					if !alloc || !fv.CanSet() {
+						continue
+					}
+					fv.Set(reflect.New(field.Type.Elem()))
+				}
+				fv = fv.Elem()
+			}
+			if field.Anonymous {
+				walkStruct(fv, section, path, alloc, fn)
+			} else {
+				walkStruct(fv, childSection(section, field), path+field.Name+".", alloc, fn)
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			continue // unexported embedded type
+		}
+
+		keySection := section
+		if s, ok := field.Tag.Lookup("section"); ok {
+			keySection = s
+		}
+		fn(field, fv, keySection, name, path+field.Name)
+	}
+}
+
+func fieldName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("ini")
+	if tag == "-" {
+		return "", false
+	}
+	if idx := strings.Index(tag, ","); idx >= 0 {
+		tag = tag[:idx]
+	}
+	if tag == "" {
+		return field.Name, true
+	}
+	return tag, true
+}
+
+func childSection(parent string, field reflect.StructField) string {
+	name, _ := fieldName(field)
+	if s, ok := field.Tag.Lookup("section"); ok {
+		name = s
+	}
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+// isSection reports if a field of type typ is mapped to a section.
+func isSection(typ reflect.Type) bool {
+	if typ.Implements(textUnmarshalerType) || reflect.PtrTo(typ).Implements(textUnmarshalerType) {
+		return false
+	}
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ.Kind() == reflect.Struct
+}
+
+// fieldValue converts a field to a value, nil pointers are skipped.
+func fieldValue(fv reflect.Value) (TValue, bool, error) {
+	if fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			return TValue{}, false, nil
+		}
+		return fieldValue(fv.Elem())
+	}
+	if marshaler, ok := fv.Interface().(encoding.TextMarshaler); ok {
+		text, err := marshaler.MarshalText()
+		if err != nil {
+			return TValue{}, false, err
+		}
+		return TValue{Value: text}, true, nil
+	}
+
+	if fv.Type() == durationType {
+		return Duration(time.Duration(fv.Int())), true, nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		return String(fv.String()), true, nil
+	case reflect.Bool:
+		return Bool(fv.Bool(), false), true, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Int64(fv.Int()), true, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Uint64(fv.Uint()), true, nil
+	case reflect.Float32, reflect.Float64:
+		return TValue{Value: []byte(strconv.FormatFloat(fv.Float(), 'g', -1, fv.Type().Bits()))}, true, nil
+	case reflect.Slice:
+		items := make([]string, fv.Len())
+		for i := range items {
+			item, _, err := fieldValue(fv.Index(i))
+			if err != nil {
+				return TValue{}, false, err
+			}
+			items[i] = string(item.Value)
+		}
+		return StringArray(items), true, nil
+	}
+	return TValue{}, false, fmt.Errorf("unsupported type %s", fv.Type())
+}
+
+func setField(fv reflect.Value, value TValue) error {
+	if fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			fv.Set(reflect.New(fv.Type().Elem()))
+		}
+		return setField(fv.Elem(), value)
+	}
+	if fv.CanAddr() && fv.Addr().Type().Implements(textUnmarshalerType) {
+		if err := fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value.String())); err != nil {
+			return value.conversionError(fv.Type().String(), err)
+		}
+		return nil
+	}
+
+	if fv.Type() == durationType {
+		d, err := value.DurationE()
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(value.String())
+	case reflect.Bool:
+		b, err := value.BoolE()
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := value.parseInt(fv.Kind().String(), fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := value.parseUint(fv.Kind().String(), fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(i)
+	case reflect.Float32:
+		f, err := value.Float32E()
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(float64(f))
+	case reflect.Float64:
+		f, err := value.Float64E()
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	case reflect.Slice:
+		items := []string{}
+		if len(value.Value) > 0 {
+			items = value.StringArray()
+		}
+		slice := reflect.MakeSlice(fv.Type(), len(items), len(items))
+		for i := range items {
+			item := TValue{Value: []byte(strings.TrimSpace(items[i])), section: value.section, key: value.key}
+			if err := setField(slice.Index(i), item); err != nil {
+				return err
+			}
+		}
+		fv.Set(slice)
+	default:
+		return value.conversionError(fv.Type().String(), errors.New("unsupported type"))
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package goini
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+type TestServerConfig struct {
+	Host    string        `ini:"host"`
+	Port    int           `ini:"port" default:"8080"`
+	Timeout time.Duration `ini:"timeout"`
+	Debug   bool          `ini:"debug"`
+	Ratio   float32       `ini:"ratio"`
+	IP      net.IP        `ini:"ip"`
+	TLS     struct {
+		Enabled bool `ini:"enabled"`
+	} `ini:"tls"`
+}
+
+type TestConfig struct {
+	Name   string           `ini:"name" section:"App"`
+	Server TestServerConfig `section:"Server"`
+	Client *struct {
+		Retries uint8    `ini:"retries"`
+		Hosts   []string `ini:"hosts"`
+		Ports   []int    `ini:"ports"`
+	}
+	Ignored string `ini:"-"`
+	hidden  string
+}
+
+func TestMapTo(t *testing.T) {
+	ini, err := LoadBytes([]byte(`[App]
+name=demo
+
+[Server]
+host=localhost
+timeout=5s
+debug=true
+ratio=0.25
+ip=10.0.0.1
+
+[Server.tls]
+enabled=1
+
+[Client]
+retries=3
+hosts=a.example.com, b.example.com
+ports=80,443`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := TestConfig{Ignored: "keep", hidden: "keep"}
+	if err := ini.MapTo(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Name != "demo" || cfg.Server.Host != "localhost" || cfg.Server.Port != 8080 ||
+		cfg.Server.Timeout != 5*time.Second || !cfg.Server.Debug || cfg.Server.Ratio != 0.25 ||
+		!cfg.Server.IP.Equal(net.ParseIP("10.0.0.1")) || !cfg.Server.TLS.Enabled {
+		t.Errorf("Unexpected values: %+v", cfg)
+	}
+	if cfg.Client == nil || cfg.Client.Retries != 3 || len(cfg.Client.Hosts) != 2 ||
+		cfg.Client.Hosts[1] != "b.example.com" || len(cfg.Client.Ports) != 2 || cfg.Client.Ports[1] != 443 {
+		t.Errorf("Unexpected client values: %+v", cfg.Client)
+	}
+	if cfg.Ignored != "keep" || cfg.hidden != "keep" {
+		t.Errorf("Expected ignored fields to keep their values")
+	}
+}
+
+func TestMapToErrors(t *testing.T) {
+	ini, err := LoadBytes([]byte(`[Server]
+port=80x
+retries=300`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg struct {
+		Server struct {
+			Host    string `ini:"host" required:"true"`
+			Port    int    `ini:"port"`
+			Retries uint8  `ini:"retries"`
+		}
+	}
+	err = Unmarshal(ini, &cfg)
+	var mappingErr *MappingError
+	if !errors.As(err, &mappingErr) {
+		t.Fatalf("Expected *MappingError, got %v", err)
+	}
+	if len(mappingErr.Errors) != 3 {
+		t.Fatalf("Expected 3 errors, got %v", mappingErr)
+	}
+	var fieldErr *FieldError
+	if !errors.As(mappingErr.Errors[0], &fieldErr) || fieldErr.Field != "Server.Host" || fieldErr.Err != ErrRequired {
+		t.Errorf("Expected Server.Host to be required, got %v", mappingErr.Errors[0])
+	}
+	var convErr *ConversionError
+	if !errors.As(mappingErr.Errors[1], &convErr) || convErr.Key != "port" || convErr.Value != "80x" {
+		t.Errorf("Expected conversion error for port, got %v", mappingErr.Errors[1])
+	}
+	if !errors.As(mappingErr.Errors[2], &convErr) || convErr.Key != "retries" || convErr.Type != "uint8" {
+		t.Errorf("Expected conversion error for retries, got %v", mappingErr.Errors[2])
+	}
+
+	if err := ini.MapTo(cfg); err == nil {
+		t.Error("Expected error mapping to a non-pointer")
+	}
+}
+
+type TestDefaultConfig struct {
+	Server struct {
+		Host    string        `ini:"host" comment:"Address to listen on"`
+		Port    int           `ini:"port" comment:"TCP port"`
+		Timeout time.Duration `ini:"timeout"`
+	}
+	Client struct {
+		Hosts []string `ini:"hosts"`
+		Ratio float64  `ini:"ratio"`
+	}
+	Optional *struct {
+		Enabled bool `ini:"enabled"`
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	cfg := TestDefaultConfig{}
+	cfg.Server.Host = "0.0.0.0"
+	cfg.Server.Port = 8080
+	cfg.Server.Timeout = 5 * time.Second
+	cfg.Client.Hosts = []string{"a", "b"}
+	cfg.Client.Ratio = 0.1
+
+	ini, err := Marshal(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `
+[Server]
+# Address to listen on
+host=0.0.0.0
+# TCP port
+port=8080
+timeout=5s
+
+[Client]
+hosts=a,b
+ratio=0.1
+`
+	if ini.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, ini.String())
+	}
+
+	var cfg2 TestDefaultConfig
+	if err := ini.MapTo(&cfg2); err != nil {
+		t.Fatal(err)
+	}
+	if cfg2.Server != cfg.Server || len(cfg2.Client.Hosts) != 2 || cfg2.Client.Ratio != 0.1 {
+		t.Errorf("Expected %+v, got %+v", cfg, cfg2)
+	}
+}
+
+func TestReflectFrom(t *testing.T) {
+	ini, err := LoadBytes([]byte(`[Server]
+# keep this comment
+host=localhost ; and this one
+port=80
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := TestDefaultConfig{}
+	cfg.Server.Host = "example.com"
+	cfg.Server.Port = 80
+	cfg.Server.Timeout = time.Minute
+	if err := ini.ReflectFrom(cfg); err != nil {
+		t.Fatal(err)
+	}
+	expected := `[Server]
+# keep this comment
+host=example.com ; and this one
+port=80
+timeout=1m0s
+
+[Client]
+hosts=
+ratio=0
+`
+	if ini.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, ini.String())
+	}
+
+	if err := ini.ReflectFrom(42); err == nil {
+		t.Error("Expected error reflecting from a non-struct")
+	}
+}
+
+func TestMarshalEmpty(t *testing.T) {
+	type Cfg struct {
+		DB struct {
+			User string   `ini:"user"`
+			Pass string   `ini:"pass"`
+			Name string   `ini:"name" comment:"Database name"`
+			Tags []string `ini:"tags"`
+		}
+	}
+	ini, err := Marshal(Cfg{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "\n[DB]\nuser=\npass=\n# Database name\nname=\ntags=\n"
+	if ini.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, ini.String())
+	}
+	var cfg Cfg
+	if err := ini.MapTo(&cfg); err != nil || cfg.DB.Name != "" || len(cfg.DB.Tags) != 0 {
+		t.Errorf("Expected an empty config, got %+v %v", cfg, err)
+	}
+
+	// Set still does not create empty keys
+	ini.Set("DB", "port", String(""))
+	if ini.Has("DB", "port") {
+		t.Errorf("Expected Set to skip an empty new key")
+	}
+}
@@ -0,0 +1,104 @@
+package goini
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+var tempCounter uint32
+
+// writeAtomic writes a temp file in the same directory as Path, syncs it and
+// renames it over Path, keeping the mode and owner of the original file.
+func writeAtomic(Path string, write func(w io.Writer) error) (err error) {
+	if resolved, err := filepath.EvalSymlinks(Path); err == nil {
+		Path = resolved
+	}
+	info, statErr := os.Stat(Path)
+
+	f, tempPath, err := createTemp(Path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(tempPath)
+		}
+	}()
+
+	w := bufio.NewWriter(f)
+	if err = write(w); err != nil {
+		return err
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if statErr == nil {
+		if err = f.Chmod(info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)); err != nil {
+			return err
+		}
+		if err = chown(f, info); err != nil {
+			return err
+		}
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tempPath, Path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(Path))
+}
+
+// createTemp creates the temp file with os.OpenFile so new files get the
+// same umask based permissions os.Create would give them.
+func createTemp(Path string) (*os.File, string, error) {
+	dir, base := filepath.Split(Path)
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf(".%s.%d-%d-%d.tmp", base, os.Getpid(), time.Now().UnixNano(), atomic.AddUint32(&tempCounter, 1))
+		f, err := os.OpenFile(filepath.Join(dir, name), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) {
+			continue
+		}
+		return f, filepath.Join(dir, name), err
+	}
+	return nil, "", fmt.Errorf("cannot create a temp file for %s", Path)
+}
+
+func backupName(Path string, n int) string {
+	if n == 0 {
+		return Path + ".bak"
+	}
+	return Path + ".bak." + strconv.Itoa(n)
+}
+
+// rotateBackups shifts Path.bak, Path.bak.1... and copies Path to Path.bak,
+// keeping at most count backups.
+func rotateBackups(Path string, count int) error {
+	src, err := os.Open(Path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	for i := count - 1; i > 0; i-- {
+		if err := os.Rename(backupName(Path, i-1), backupName(Path, i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return writeAtomic(backupName(Path, 0), func(w io.Writer) error {
+		_, err := io.Copy(w, src)
+		return err
+	})
+}
@@ -0,0 +1,151 @@
+package goini
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+)
+
+type TEventType int8
+
+const (
+	EventSection TEventType = iota
+	EventKey
+	EventComment
+)
+
+// TEvent is a section header, a key or a comment line found by Scan.
+type TEvent struct {
+	Type    TEventType
+	Section string // section of the key or the comment, "" before the first one
+	Key     string
+	Value   TValue
+	Comment string // the comment line without the spaces around it
+	Line    int    // 1-based line number, the first one of a multi-line value
+}
+
+// Scan reads the INI data calling fn for every section, key and comment
+// without keeping the file in memory, it stops on the first error of fn.
+// With the Strict option it fails with a *ParseError on a malformed line,
+// otherwise a line that is not a section, a key or a comment is skipped.
+// DuplicatePolicy is not applied.
+func Scan(r io.Reader, o *TOptions, fn func(TEvent) error) error {
+	t := New(o)
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), int(^uint(0)>>1))
+	s.Split(scanLines)
+
+	pending := []string{} // lines read to find the end of a multi-line value
+	numbers := []int{}    // line numbers of pending
+	number := 0
+	read := func() bool {
+		for s.Scan() {
+			number++
+			if len(s.Bytes()) > 0 || !t.options.DontPreserveEmptyLines {
+				pending = append(pending, s.Text())
+				numbers = append(numbers, number)
+				return true
+			}
+		}
+		return false
+	}
+	// peek reads the lines continuation needs to decide
+	peek := func() {
+		for len(pending) == 0 || (t.options.MultiLine == MultiLineIndented &&
+			len(strings.TrimSpace(pending[len(pending)-1])) == 0) {
+			if !read() {
+				return
+			}
+		}
+	}
+
+	section := ""
+	for {
+		if len(pending) == 0 && !read() {
+			break
+		}
+		l, lineNumber := pending[0], numbers[0]
+		pending, numbers = pending[1:], numbers[1:]
+		r := t.parseLine(l)
+		if r.Mode == KEY && t.options.MultiLine != MultiLineNone {
+			for {
+				peek()
+				n := t.continuation(l, r, pending)
+				if n == 0 {
+					break
+				}
+				l += "\n" + strings.Join(pending[:n], "\n")
+				pending, numbers = pending[n:], numbers[n:]
+				r = t.parseLine(l)
+			}
+		}
+		if r.Mode != SECTION {
+			r.Section = section
+		}
+
+		if reason, column := t.lineProblem(r); reason != "" {
+			if t.options.Strict {
+				snippet := l
+				if n := strings.IndexByte(snippet, '\n'); n >= 0 {
+					snippet = snippet[:n]
+				}
+				return &ParseError{Line: lineNumber, Column: column + 1, Snippet: snippet, Reason: reason}
+			}
+			if r.Mode == IGNORED {
+				continue
+			}
+		}
+
+		var event TEvent
+		switch r.Mode {
+		case SECTION:
+			section = r.Section
+			event = TEvent{Type: EventSection, Section: section, Line: lineNumber}
+		case KEY:
+			event = TEvent{Type: EventKey, Section: section, Key: r.Key, Line: lineNumber}
+			event.Value = TValue{
+				Value:     []byte(t.valueToRead(r.Value)),
+				section:   section,
+				key:       r.Key,
+				separator: t.arraySeparator(),
+			}
+		default:
+			comment := strings.TrimSpace(l)
+			if len(comment) == 0 {
+				continue
+			}
+			event = TEvent{Type: EventComment, Section: section, Comment: comment, Line: lineNumber}
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+
+// scanLines is a bufio.SplitFunc for lines ending in LF, CRLF or CR.
+func scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return i + 1, data[:i], nil
+		}
+		return 0, nil, nil // \r at the end of data, it may be \r\n
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
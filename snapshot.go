@@ -0,0 +1,149 @@
+package goini
+
+import (
+	"io"
+	"time"
+)
+
+// Clone returns a copy of the file with its own options. The lines are
+// shared until one of the files is changed.
+func (t *TINIFile) Clone() *TINIFile {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.shared = true
+
+	return &TINIFile{
+		lines:        t.lines,
+		sections:     t.sections,
+		Filename:     t.Filename,
+		TotalLines:   t.TotalLines,
+		LineEnding:   t.LineEnding,
+		noFinalEOL:   t.noFinalEOL,
+		options:      copyOptions(t.options),
+		warnings:     t.warnings,
+		sectionIndex: t.sectionIndex,
+		keyIndex:     t.keyIndex,
+		shared:       true,
+	}
+}
+
+// copyOptions returns a copy of o that does not share its slices.
+func copyOptions(o *TOptions) *TOptions {
+	options := *o
+	options.Delimiters = append([]string(nil), o.Delimiters...)
+	options.CommentPrefixes = append([]string(nil), o.CommentPrefixes...)
+	return &options
+}
+
+// own copies the lines shared with a clone before changing them, the lock
+// must be held.
+func (t *TINIFile) own() {
+	if !t.shared {
+		return
+	}
+	t.lines = append([]_TLine(nil), t.lines...)
+	t.sections = append([]_TSection(nil), t.sections...)
+	t.warnings = append([]*ParseError(nil), t.warnings...)
+	sectionIndex := make(map[string]int, len(t.sectionIndex))
+	for section, i := range t.sectionIndex {
+		sectionIndex[section] = i
+	}
+	t.sectionIndex = sectionIndex
+	keyIndex := make(map[string]map[string][]int, len(t.keyIndex))
+	for section, keys := range t.keyIndex {
+		keyIndex[section] = make(map[string][]int, len(keys))
+		for key, lines := range keys {
+			keyIndex[section][key] = append([]int(nil), lines...)
+		}
+	}
+	t.keyIndex = keyIndex
+	t.shared = false
+}
+
+// TSnapshot is a read-only view of a file, later changes to the file do not
+// change it.
+type TSnapshot struct {
+	file *TINIFile
+}
+
+// Snapshot returns a read-only view of the file as it is now.
+func (t *TINIFile) Snapshot() *TSnapshot {
+	return &TSnapshot{file: t.Clone()}
+}
+
+// Clone returns a file with the contents of the snapshot that can be changed.
+func (s *TSnapshot) Clone() *TINIFile {
+	return s.file.Clone()
+}
+
+func (s *TSnapshot) Get(section string, key string) TValue {
+	return s.file.Get(section, key)
+}
+
+func (s *TSnapshot) Lookup(section string, key string) (TValue, bool) {
+	return s.file.Lookup(section, key)
+}
+
+func (s *TSnapshot) GetAll(section string, key string) []TValue {
+	return s.file.GetAll(section, key)
+}
+
+func (s *TSnapshot) Has(section string, key string) bool {
+	return s.file.Has(section, key)
+}
+
+func (s *TSnapshot) GetStringOr(section string, key string, def string) string {
+	return s.file.GetStringOr(section, key, def)
+}
+
+func (s *TSnapshot) GetBoolOr(section string, key string, def bool) bool {
+	return s.file.GetBoolOr(section, key, def)
+}
+
+func (s *TSnapshot) GetIntOr(section string, key string, def int) int {
+	return s.file.GetIntOr(section, key, def)
+}
+
+func (s *TSnapshot) GetInt64Or(section string, key string, def int64) int64 {
+	return s.file.GetInt64Or(section, key, def)
+}
+
+func (s *TSnapshot) GetUint64Or(section string, key string, def uint64) uint64 {
+	return s.file.GetUint64Or(section, key, def)
+}
+
+func (s *TSnapshot) GetFloat64Or(section string, key string, def float64) float64 {
+	return s.file.GetFloat64Or(section, key, def)
+}
+
+func (s *TSnapshot) GetDurationOr(section string, key string, def time.Duration) time.Duration {
+	return s.file.GetDurationOr(section, key, def)
+}
+
+func (s *TSnapshot) Sections() []string {
+	return s.file.Sections()
+}
+
+func (s *TSnapshot) Keys(section string) []string {
+	return s.file.Keys(section)
+}
+
+func (s *TSnapshot) Range(fn func(section string, key string, value TValue) bool) {
+	s.file.Range(fn)
+}
+
+func (s *TSnapshot) MapTo(v interface{}) error {
+	return s.file.MapTo(v)
+}
+
+func (s *TSnapshot) WriteTo(w io.Writer) (int64, error) {
+	return s.file.WriteTo(w)
+}
+
+func (s *TSnapshot) Bytes() []byte {
+	return s.file.Bytes()
+}
+
+func (s *TSnapshot) String() string {
+	return s.file.String()
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Conversions
@@ -13,15 +14,22 @@ func String(s string) TValue {
 }
 
 func (t TValue) String() string {
-	return string(ValueToRead(t.Value))
+	return string(t.Value)
 }
 
 func StringArray(s []string) TValue {
-	return TValue{Value: []byte(strings.Join(s, string(_ArraySeparator)))}
+	return TValue{Value: []byte(strings.Join(s, string(_ArraySeparator))), array: s}
 }
 
 func (t TValue) StringArray() []string {
-	return strings.Split(string(t.Value), string(_ArraySeparator))
+	if t.array != nil {
+		return append([]string{}, t.array...) // every value of a repeated key
+	}
+	separator := t.separator
+	if len(separator) == 0 {
+		separator = string(_ArraySeparator)
+	}
+	return strings.Split(string(t.Value), separator)
 }
 
 func Bool(b bool, isInt bool) TValue {
@@ -41,12 +49,20 @@ func Bool(b bool, isInt bool) TValue {
 }
 
 func (t TValue) Bool() bool {
-	b := false
+	b, _ := t.BoolE()
+	return b
+}
+
+func (t TValue) BoolE() (bool, error) {
 	if strings.EqualFold(string(t.Value), "true") ||
 		string(t.Value) == "1" {
-		b = true
+		return true, nil
 	}
-	return b
+	if strings.EqualFold(string(t.Value), "false") ||
+		string(t.Value) == "0" {
+		return false, nil
+	}
+	return false, t.conversionError("bool", strconv.ErrSyntax)
 }
 
 func Byte(i byte) TValue {
@@ -55,8 +71,13 @@ func Byte(i byte) TValue {
 }
 
 func (t TValue) Byte() byte {
-	i, _ := strconv.Atoi(string(t.Value))
-	return byte(i)
+	i, _ := t.ByteE()
+	return i
+}
+
+func (t TValue) ByteE() (byte, error) {
+	i, err := t.parseUint("byte", 8)
+	return byte(i), err
 }
 
 func Int(i int) TValue {
@@ -65,18 +86,28 @@ func Int(i int) TValue {
 }
 
 func (t TValue) Int() int {
-	i, _ := strconv.Atoi(string(t.Value))
+	i, _ := t.IntE()
 	return i
 }
 
+func (t TValue) IntE() (int, error) {
+	i, err := t.parseInt("int", strconv.IntSize)
+	return int(i), err
+}
+
 func Int8(i int8) TValue {
 	s := strconv.Itoa(int(i))
 	return TValue{Value: []byte(s)}
 }
 
 func (t TValue) Int8() int8 {
-	i, _ := strconv.Atoi(string(t.Value))
-	return int8(i)
+	i, _ := t.Int8E()
+	return i
+}
+
+func (t TValue) Int8E() (int8, error) {
+	i, err := t.parseInt("int8", 8)
+	return int8(i), err
 }
 
 func Int16(i int16) TValue {
@@ -85,8 +116,13 @@ func Int16(i int16) TValue {
 }
 
 func (t TValue) Int16() int16 {
-	i, _ := strconv.Atoi(string(t.Value))
-	return int16(i)
+	i, _ := t.Int16E()
+	return i
+}
+
+func (t TValue) Int16E() (int16, error) {
+	i, err := t.parseInt("int16", 16)
+	return int16(i), err
 }
 
 func Int32(i int32) TValue {
@@ -95,18 +131,27 @@ func Int32(i int32) TValue {
 }
 
 func (t TValue) Int32() int32 {
-	i, _ := strconv.Atoi(string(t.Value))
-	return int32(i)
+	i, _ := t.Int32E()
+	return i
+}
+
+func (t TValue) Int32E() (int32, error) {
+	i, err := t.parseInt("int32", 32)
+	return int32(i), err
 }
 
 func Int64(i int64) TValue {
-	s := strconv.Itoa(int(i))
+	s := strconv.FormatInt(i, 10)
 	return TValue{Value: []byte(s)}
 }
 
 func (t TValue) Int64() int64 {
-	i, _ := strconv.Atoi(string(t.Value))
-	return int64(i)
+	i, _ := t.Int64E()
+	return i
+}
+
+func (t TValue) Int64E() (int64, error) {
+	return t.parseInt("int64", 64)
 }
 
 func Float32(i float32) TValue {
@@ -115,8 +160,16 @@ func Float32(i float32) TValue {
 }
 
 func (t TValue) Float32() float32 {
-	i, _ := strconv.ParseFloat(string(t.Value), 32)
-	return float32(i)
+	i, _ := t.Float32E()
+	return i
+}
+
+func (t TValue) Float32E() (float32, error) {
+	i, err := strconv.ParseFloat(string(t.Value), 32)
+	if err != nil {
+		return 0, t.conversionError("float32", err)
+	}
+	return float32(i), nil
 }
 
 func Float64(i float64) TValue {
@@ -125,16 +178,94 @@ func Float64(i float64) TValue {
 }
 
 func (t TValue) Float64() float64 {
-	i, _ := strconv.ParseFloat(string(t.Value), 64)
+	i, _ := t.Float64E()
 	return i
 }
 
+func (t TValue) Float64E() (float64, error) {
+	i, err := strconv.ParseFloat(string(t.Value), 64)
+	if err != nil {
+		return 0, t.conversionError("float64", err)
+	}
+	return i, nil
+}
+
 func Uint64(i uint64) TValue {
 	s := strconv.FormatUint(i, 10)
 	return TValue{Value: []byte(s)}
 }
 
 func (t TValue) Uint64() uint64 {
-	i, _ := strconv.ParseUint(string(t.Value), 10, 64)
+	i, _ := t.Uint64E()
 	return i
 }
+
+func (t TValue) Uint64E() (uint64, error) {
+	return t.parseUint("uint64", 64)
+}
+
+func Duration(d time.Duration) TValue {
+	return TValue{Value: []byte(d.String())}
+}
+
+func (t TValue) Duration() time.Duration {
+	d, _ := t.DurationE()
+	return d
+}
+
+func (t TValue) DurationE() (time.Duration, error) {
+	d, err := time.ParseDuration(string(t.Value))
+	if err != nil {
+		return 0, t.conversionError("duration", strconv.ErrSyntax)
+	}
+	return d, nil
+}
+
+// Errors
+
+// ConversionError is returned by the E getters when the value cannot be
+// converted to the requested type.
+type ConversionError struct {
+	Section string
+	Key     string
+	Value   string
+	Type    string
+	Err     error // strconv.ErrSyntax or strconv.ErrRange
+}
+
+func (e *ConversionError) Error() string {
+	return fmt.Sprintf("goini: cannot convert [%s] %s=%q to %s: %v", e.Section, e.Key, e.Value, e.Type, e.Err)
+}
+
+func (e *ConversionError) Unwrap() error {
+	return e.Err
+}
+
+func (t TValue) conversionError(typeName string, err error) error {
+	if numErr, ok := err.(*strconv.NumError); ok {
+		err = numErr.Err
+	}
+	return &ConversionError{
+		Section: t.section,
+		Key:     t.key,
+		Value:   string(t.Value),
+		Type:    typeName,
+		Err:     err,
+	}
+}
+
+func (t TValue) parseInt(typeName string, bitSize int) (int64, error) {
+	i, err := strconv.ParseInt(string(t.Value), 10, bitSize)
+	if err != nil {
+		return 0, t.conversionError(typeName, err)
+	}
+	return i, nil
+}
+
+func (t TValue) parseUint(typeName string, bitSize int) (uint64, error) {
+	i, err := strconv.ParseUint(string(t.Value), 10, bitSize)
+	if err != nil {
+		return 0, t.conversionError(typeName, err)
+	}
+	return i, nil
+}
@@ -0,0 +1,207 @@
+package goini
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"sync"
+	"time"
+)
+
+type TChangeType int8
+
+const (
+	ChangeAdded TChangeType = iota
+	ChangeRemoved
+	ChangeModified
+)
+
+// TChange is a section or a key that changed between two files, Key is empty
+// when the whole section was added or removed.
+type TChange struct {
+	Type    TChangeType
+	Section string
+	Key     string
+	Old     TValue
+	New     TValue
+}
+
+// Diff returns the sections and keys added, removed or modified from oldFile
+// to newFile. An added or removed section comes with a change for each key.
+func Diff(oldFile *TINIFile, newFile *TINIFile) []TChange {
+	changes := []TChange{}
+	oldSections := oldFile.Sections()
+	newSections := newFile.Sections()
+	has := func(sections []string, section string, file *TINIFile) bool {
+		for _, s := range sections {
+			if file.sectionKey(s) == file.sectionKey(section) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, section := range oldSections {
+		removed := !has(newSections, section, newFile)
+		if removed {
+			changes = append(changes, TChange{Type: ChangeRemoved, Section: section})
+		}
+		for _, key := range oldFile.Keys(section) {
+			before := oldFile.Get(section, key)
+			after, found := newFile.Lookup(section, key)
+			if removed || !found {
+				changes = append(changes, TChange{Type: ChangeRemoved, Section: section, Key: key, Old: before})
+			} else if !bytes.Equal(before.Value, after.Value) {
+				changes = append(changes, TChange{Type: ChangeModified, Section: section, Key: key, Old: before, New: after})
+			}
+		}
+	}
+	for _, section := range newSections {
+		added := !has(oldSections, section, oldFile)
+		if added {
+			changes = append(changes, TChange{Type: ChangeAdded, Section: section})
+		}
+		for _, key := range newFile.Keys(section) {
+			if added || !oldFile.Has(section, key) {
+				changes = append(changes, TChange{Type: ChangeAdded, Section: section, Key: key, New: newFile.Get(section, key)})
+			}
+		}
+	}
+	return changes
+}
+
+type TWatchOptions struct {
+	Options  *TOptions       // used to load the file
+	Interval time.Duration   // between checks of the file, default 1s
+	Debounce time.Duration   // the file has to be unchanged this long to be loaded, default Interval
+	OnError  func(err error) // the file could not be read or loaded, the last good one is kept
+}
+
+// TWatcher reloads a file when it changes on disk.
+type TWatcher struct {
+	path    string
+	options TWatchOptions
+	fn      func(old *TINIFile, new *TINIFile, changes []TChange)
+
+	mu   sync.Mutex
+	file *TINIFile
+	hash [sha256.Size]byte
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// Watch loads the file and checks it every Interval without external
+// dependencies. When its modification time or size change and it stays the
+// same for Debounce, it is loaded again and fn is called with the changes if
+// its contents are different. The first Load error is returned.
+func Watch(path string, o *TWatchOptions, fn func(old *TINIFile, new *TINIFile, changes []TChange)) (*TWatcher, error) {
+	w := &TWatcher{
+		path: path,
+		fn:   fn,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if o != nil {
+		w.options = *o
+	}
+	if w.options.Interval <= 0 {
+		w.options.Interval = time.Second
+	}
+	if w.options.Debounce <= 0 {
+		w.options.Debounce = w.options.Interval
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.reload(); err != nil {
+		return nil, err
+	}
+	go w.run(info)
+	return w, nil
+}
+
+// File returns the last file loaded without errors.
+func (w *TWatcher) File() *TINIFile {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file
+}
+
+// Close stops watching the file, fn is not called after it returns.
+func (w *TWatcher) Close() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+	<-w.done
+}
+
+func (w *TWatcher) run(last os.FileInfo) {
+	defer close(w.done)
+	ticker := time.NewTicker(w.options.Interval)
+	defer ticker.Stop()
+
+	var changed time.Time // when the last change was seen, zero if it was loaded
+	for {
+		select {
+		case <-w.stop:
+			return
+		case now := <-ticker.C:
+			info, err := os.Stat(w.path)
+			if err != nil {
+				// it may be replaced, it is read when it is back
+				changed = now
+				continue
+			}
+			if !info.ModTime().Equal(last.ModTime()) || info.Size() != last.Size() {
+				last = info
+				changed = now
+				continue
+			}
+			if changed.IsZero() || now.Sub(changed) < w.options.Debounce {
+				continue
+			}
+			changed = time.Time{}
+			if err := w.reload(); err != nil && w.options.OnError != nil {
+				w.options.OnError(err)
+			}
+		}
+	}
+}
+
+// reload loads the file if its contents changed and calls fn with the changes.
+func (w *TWatcher) reload() error {
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(data)
+
+	w.mu.Lock()
+	old := w.file
+	if old != nil && hash == w.hash {
+		w.mu.Unlock()
+		return nil
+	}
+	w.hash = hash // a broken file is not loaded again until it changes
+	w.mu.Unlock()
+
+	file, err := load(bytes.NewReader(data), w.path, w.options.Options)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	w.file = file
+	w.mu.Unlock()
+	if old == nil || w.fn == nil {
+		return nil
+	}
+	if changes := Diff(old, file); len(changes) > 0 {
+		w.fn(old, file, changes)
+	}
+	return nil
+}